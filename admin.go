@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/msksgm/go-techblog-msksgm/config"
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/postgres"
+)
+
+// adminCommand runs an action of the user, article or token commands. Its
+// arguments are those after the action name.
+type adminCommand func(ctx context.Context, env *adminEnv, args []string) error
+
+// adminEnv gives admin commands the same services the server uses, so that
+// changes made from the command line follow the same rules.
+type adminEnv struct {
+	cfg      *config.Config
+	users    *postgres.UserService
+	articles *postgres.ArticleService
+	pats     *postgres.PersonalAccessTokenService
+}
+
+// runAdmin loads the configuration from args, picks the action named by the
+// first remaining argument and runs it against the database. usage is
+// returned when the action is missing or unknown.
+func runAdmin(args []string, actions map[string]adminCommand, usage error) error {
+	cfg, err := loadConfig(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Args) == 0 {
+		return usage
+	}
+
+	action, ok := actions[cfg.Args[0]]
+	if !ok {
+		return usage
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := checkAdminSchema(ctx, db); err != nil {
+		return err
+	}
+
+	env := &adminEnv{
+		cfg:      cfg,
+		users:    postgres.NewUserService(db),
+		articles: postgres.NewArticleService(db),
+		pats:     postgres.NewPersonalAccessTokenService(db),
+	}
+
+	return action(ctx, env, cfg.Args[1:])
+}
+
+// checkAdminSchema refuses to change data unless the schema is exactly the
+// one this binary was built for.
+func checkAdminSchema(ctx context.Context, db *postgres.DB) error {
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.CheckSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("database schema is at version %d but %d is required, run techblog migrate up", status.Current, status.Latest)
+	}
+
+	return nil
+}
+
+// parseActionFlags parses the flags of an action and checks the number of
+// positional arguments left.
+func parseActionFlags(fs *flag.FlagSet, args []string, nargs int, usage error) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() != nargs {
+		return nil, usage
+	}
+
+	return fs.Args(), nil
+}
+
+// findUser looks up a user by username and names them in the error.
+func findUser(ctx context.Context, env *adminEnv, username string) (*model.User, error) {
+	user, err := env.users.UserByUsername(ctx, username)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	return user, err
+}
+
+// newPassword reads the password from the first line of r when fromInput is
+// set and checks it against the password policy. Otherwise it generates a
+// random password, which is returned with generated set.
+func newPassword(env *adminEnv, r io.Reader, fromInput bool, username string) (password string, generated bool, err error) {
+	if !fromInput {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return "", false, err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), true, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", false, err
+		}
+		return "", false, errors.New("no password on standard input")
+	}
+	password = strings.TrimRight(scanner.Text(), "\r")
+
+	if n := len(password); n < 8 || n > 256 {
+		return "", false, errors.New("password must be between 8 and 256 characters")
+	}
+
+	policy, err := passwordPolicy(env.cfg.Password)
+	if err != nil {
+		return "", false, err
+	}
+
+	violations, err := policy.Violations(password, username)
+	if err != nil {
+		return "", false, err
+	}
+	if len(violations) > 0 {
+		return "", false, fmt.Errorf("password refused: %s", strings.Join(violations, "; "))
+	}
+
+	return password, false, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/config"
+)
+
+func Test_parseActionFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		nargs    int
+		wantArgs []string
+		wantErr  error
+	}{
+		{"no arguments", nil, 0, nil, nil},
+		{"positional arguments", []string{"alice", "admin"}, 2, []string{"alice", "admin"}, nil},
+		{"flags before arguments", []string{"-force", "alice"}, 1, []string{"alice"}, nil},
+		{"too few arguments", []string{"alice"}, 2, nil, errUserUsage},
+		{"too many arguments", []string{"alice", "bob"}, 1, nil, errUserUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Bool("force", false, "")
+
+			got, err := parseActionFlags(fs, tt.args, tt.nargs, errUserUsage)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, but got %v", tt.wantErr, err)
+			}
+
+			if err == nil && len(got)+len(tt.wantArgs) > 0 && !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("expected arguments %v, but got %v", tt.wantArgs, got)
+			}
+		})
+	}
+
+	t.Run("unknown flag", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+
+		if _, err := parseActionFlags(fs, []string{"-bogus"}, 0, errUserUsage); err == nil {
+			t.Error("expected an unknown flag to be refused")
+		}
+	})
+}
+
+func Test_newPassword(t *testing.T) {
+	env := &adminEnv{cfg: &config.Config{Password: config.Password{MinScore: 3}}}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"accepted", "vT9#kq2!Lm\n", "vT9#kq2!Lm", ""},
+		{"carriage return", "vT9#kq2!Lm\r\n", "vT9#kq2!Lm", ""},
+		{"empty input", "", "", "no password on standard input"},
+		{"too short", "aB3$\n", "", "between 8 and 256 characters"},
+		{"too long", strings.Repeat("a", 257) + "\n", "", "between 8 and 256 characters"},
+		{"too weak", "password123\n", "", "password refused"},
+		{"contains username", "alice-vT9#kq2!Lm\n", "", "password refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, generated, err := newPassword(env, strings.NewReader(tt.input), true, "alice")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, but got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want || generated {
+				t.Errorf("expected password %q read from input, but got %q (generated %v)", tt.want, got, generated)
+			}
+		})
+	}
+
+	t.Run("generated", func(t *testing.T) {
+		first, generated, err := newPassword(env, nil, false, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, _, err := newPassword(env, nil, false, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !generated || len(first) < 20 || first == second {
+			t.Errorf("expected distinct random passwords, but got %q and %q", first, second)
+		}
+	})
+}
+
+// Argument errors are reported before an action touches the database, so a
+// nil environment is never used.
+func Test_adminCommands_arguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		command adminCommand
+		args    []string
+		wantErr string
+	}{
+		{"user create without flags", createUser, nil, "-username must be at least 2 characters"},
+		{"user create with reserved username", createUser, []string{"-username", "admin", "-email", "a@example.com"}, "is reserved"},
+		{"user create with bad email", createUser, []string{"-username", "alice", "-email", "alice"}, "-email must be an email address"},
+		{"user create with unknown role", createUser, []string{"-username", "alice", "-email", "a@example.com", "-role", "root"}, "unknown role"},
+		{"user create with arguments", createUser, []string{"alice"}, errUserUsage.Error()},
+		{"user list with unknown role", listUsers, []string{"-role", "root"}, "unknown role"},
+		{"user set-role without role", setUserRole, []string{"alice"}, errUserUsage.Error()},
+		{"user set-role with unknown role", setUserRole, []string{"alice", "root"}, "unknown role"},
+		{"user reset-password without username", resetUserPassword, nil, errUserUsage.Error()},
+		{"article unpublish without slug", unpublishArticle, nil, errArticleUsage.Error()},
+		{"article reassign without username", reassignArticle, []string{"slug"}, errArticleUsage.Error()},
+		{"token revoke without id", revokeTokens, nil, errTokenUsage.Error()},
+		{"token revoke with bad id", revokeTokens, []string{"abc"}, `invalid token id "abc"`},
+		{"token revoke with user and id", revokeTokens, []string{"-user", "alice", "1"}, errTokenUsage.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.command(context.Background(), nil, tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
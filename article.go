@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var errArticleUsage = usageError(`usage: techblog article [flags] <action>
+
+actions:
+  list [-author <username>] [-trashed] [-limit n] [-offset n]
+  unpublish <slug>
+  reassign <slug> <username>`)
+
+// article moderates articles from the command line.
+func article(args []string) error {
+	return runAdmin(args, map[string]adminCommand{
+		"list":      listArticles,
+		"unpublish": unpublishArticle,
+		"reassign":  reassignArticle,
+	}, errArticleUsage)
+}
+
+func listArticles(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("article list", flag.ContinueOnError)
+	author := fs.String("author", "", "only list articles the user is an author of")
+	trashed := fs.Bool("trashed", false, "list articles in the trash instead of published ones")
+	limit := fs.Int("limit", 50, "maximum number of articles to list")
+	offset := fs.Int("offset", 0, "number of articles to skip")
+
+	if _, err := parseActionFlags(fs, args, 0, errArticleUsage); err != nil {
+		return err
+	}
+
+	filter := model.ArticleFilter{Trashed: *trashed, Limit: *limit, Offset: *offset}
+	if *author != "" {
+		filter.AuthorUsername = author
+	}
+
+	articles, err := env.articles.Articles(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSLUG\tAUTHOR\tCREATED\tDELETED\tTITLE")
+	for _, a := range articles {
+		deleted := "-"
+		if a.DeletedAt != nil {
+			deleted = a.DeletedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			a.ID, a.Slug, a.Author.Username, a.CreatedAt.Format(time.RFC3339), deleted, a.Title)
+	}
+
+	return w.Flush()
+}
+
+// unpublishArticle moves an article to the trash, as its author would. It
+// can be restored until it is purged.
+func unpublishArticle(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("article unpublish", flag.ContinueOnError)
+
+	args, err := parseActionFlags(fs, args, 1, errArticleUsage)
+	if err != nil {
+		return err
+	}
+
+	a, err := findArticle(ctx, env, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := env.articles.DeleteArticle(ctx, a); err != nil {
+		return err
+	}
+
+	fmt.Printf("moved %s to the trash, it is purged after %s\n", a.Slug, env.cfg.Articles.Retention)
+
+	return nil
+}
+
+func reassignArticle(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("article reassign", flag.ContinueOnError)
+
+	args, err := parseActionFlags(fs, args, 2, errArticleUsage)
+	if err != nil {
+		return err
+	}
+
+	a, err := findArticle(ctx, env, args[0])
+	if err != nil {
+		return err
+	}
+
+	u, err := findUser(ctx, env, args[1])
+	if err != nil {
+		return err
+	}
+
+	if a.AuthorID == u.ID {
+		return fmt.Errorf("%s is already the author of %s", u.Username, a.Slug)
+	}
+
+	if err := env.articles.ReassignArticle(ctx, a.ID, u.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("reassigned %s from %s to %s\n", a.Slug, a.Author.Username, u.Username)
+
+	return nil
+}
+
+// findArticle looks up a published article by slug and names it in the error.
+func findArticle(ctx context.Context, env *adminEnv, slug string) (*model.Article, error) {
+	a, err := env.articles.ArticleBySlug(ctx, slug)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, fmt.Errorf("article %q not found", slug)
+	}
+	return a, err
+}
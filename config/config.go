@@ -0,0 +1,304 @@
+// Package config loads the configuration of the server from a YAML or TOML
+// file, the environment and command-line flags.
+//
+// Every setting has a key in the file, an environment variable and a flag.
+// Flags are named after the key, for example server.read_timeout is set with
+// -server.read-timeout. Later sources override earlier ones:
+//
+//	defaults < file < environment < flags
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Server    Server    `config:"server"`
+	Database  Database  `config:"database"`
+	Auth      Auth      `config:"auth"`
+	CORS      CORS      `config:"cors"`
+	RateLimit RateLimit `config:"rate_limit"`
+	Features  Features  `config:"features"`
+	Articles  Articles  `config:"articles"`
+	Password  Password  `config:"password"`
+	Mail      Mail      `config:"mail"`
+	OIDC      OIDC      `config:"oidc"`
+	Tracing   Tracing   `config:"tracing"`
+	Log       Log       `config:"log"`
+
+	// File is the config file that was loaded, if any.
+	File string `config:"-"`
+	// PrintConfig asks for the effective configuration to be printed
+	// instead of starting the server.
+	PrintConfig bool `config:"-"`
+	// Args are the arguments left after the flags.
+	Args []string `config:"-"`
+}
+
+type Server struct {
+	Port            string        `config:"port" env:"PORT" usage:"port to listen on"`
+	PublicURL       string        `config:"public_url" env:"PUBLIC_URL" usage:"externally reachable base URL, defaults to http://localhost:<port>"`
+	ReadTimeout     time.Duration `config:"read_timeout" env:"SERVER_READ_TIMEOUT" usage:"maximum duration for reading a request"`
+	WriteTimeout    time.Duration `config:"write_timeout" env:"SERVER_WRITE_TIMEOUT" usage:"maximum duration for writing a response"`
+	IdleTimeout     time.Duration `config:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" usage:"how long idle keep-alive connections stay open"`
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long to wait for requests and workers on shutdown"`
+	DrainDelay      time.Duration `config:"drain_delay" env:"SERVER_DRAIN_DELAY" usage:"how long to keep serving after failing the readiness probe on shutdown"`
+	TrustedProxies  []string      `config:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"IPs or CIDR ranges of proxies whose X-Forwarded-For header is trusted, comma separated"`
+}
+
+type Database struct {
+	URL             string        `config:"url" env:"POSTGRESQL_URL" secret:"url" usage:"PostgreSQL connection URL"`
+	MaxOpenConns    int           `config:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"maximum number of open connections, 0 for no limit"`
+	MaxIdleConns    int           `config:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"maximum number of idle connections"`
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"maximum lifetime of a connection, 0 for no limit"`
+	ConnMaxIdleTime time.Duration `config:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" usage:"maximum idle time of a connection, 0 for no limit"`
+	AutoMigrate     bool          `config:"auto_migrate" env:"DB_AUTO_MIGRATE" usage:"apply pending migrations when the server starts"`
+}
+
+type Auth struct {
+	JWTSecret string `config:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"key signing user tokens, at least 32 bytes"`
+}
+
+type CORS struct {
+	AllowedOrigins   []string      `config:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" usage:"origins allowed to call the API, such as https://app.example.com or https://*.example.com, comma separated"`
+	AllowedMethods   []string      `config:"allowed_methods" env:"CORS_ALLOWED_METHODS" usage:"methods allowed in cross-origin requests, comma separated"`
+	AllowedHeaders   []string      `config:"allowed_headers" env:"CORS_ALLOWED_HEADERS" usage:"headers allowed in cross-origin requests, comma separated"`
+	ExposedHeaders   []string      `config:"exposed_headers" env:"CORS_EXPOSED_HEADERS" usage:"response headers scripts may read, comma separated"`
+	AllowCredentials bool          `config:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" usage:"allow cross-origin requests with credentials"`
+	MaxAge           time.Duration `config:"max_age" env:"CORS_MAX_AGE" usage:"how long browsers may cache preflight responses"`
+}
+
+type RateLimit struct {
+	Enabled bool   `config:"enabled" env:"RATE_LIMIT_ENABLED" usage:"limit how often clients may call expensive endpoints"`
+	Backend string `config:"backend" env:"RATE_LIMIT_BACKEND" usage:"where request counts are kept: memory, or postgres to share them between replicas"`
+}
+
+type Features struct {
+	RequireVerifiedEmail bool `config:"require_verified_email" env:"REQUIRE_VERIFIED_EMAIL" usage:"prevent users from publishing until they verify their email"`
+}
+
+type Articles struct {
+	Retention time.Duration `config:"retention" env:"ARTICLE_RETENTION" usage:"how long deleted articles stay in the trash"`
+}
+
+type Password struct {
+	MinScore     int    `config:"min_score" env:"PASSWORD_MIN_SCORE" usage:"minimum password strength score from 0 to 4"`
+	BreachedFile string `config:"breached_file" env:"BREACHED_PASSWORDS_FILE" usage:"file of breached password hashes"`
+}
+
+type Mail struct {
+	From         string `config:"from" env:"MAIL_FROM" usage:"sender address of emails"`
+	SMTPHost     string `config:"smtp_host" env:"SMTP_HOST" usage:"SMTP server, emails are written to mail.file or stdout when empty"`
+	SMTPPort     int    `config:"smtp_port" env:"SMTP_PORT" usage:"SMTP server port"`
+	SMTPUsername string `config:"smtp_username" env:"SMTP_USERNAME" usage:"SMTP user name"`
+	SMTPPassword string `config:"smtp_password" env:"SMTP_PASSWORD" secret:"true" usage:"SMTP password"`
+	File         string `config:"file" env:"MAIL_FILE" usage:"file emails are appended to when no SMTP server is set"`
+}
+
+type OIDC struct {
+	Issuer       string   `config:"issuer" env:"OIDC_ISSUER" usage:"issuer URL of the identity provider, disabled when empty"`
+	ClientID     string   `config:"client_id" env:"OIDC_CLIENT_ID" usage:"OIDC client ID"`
+	ClientSecret string   `config:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" usage:"OIDC client secret"`
+	RedirectURL  string   `config:"redirect_url" env:"OIDC_REDIRECT_URL" usage:"OIDC redirect URL, defaults to the callback under public_url"`
+	Scopes       []string `config:"scopes" env:"OIDC_SCOPES" usage:"OIDC scopes, comma separated"`
+}
+
+type Tracing struct {
+	Exporter    string `config:"exporter" env:"TRACE_EXPORTER" usage:"trace exporter: stdout or otlp, disabled when empty"`
+	ServiceName string `config:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name reported in traces"`
+}
+
+type Log struct {
+	Level  string `config:"level" env:"LOG_LEVEL" usage:"log level: debug, info, warn or error"`
+	Format string `config:"format" env:"LOG_FORMAT" usage:"log format: json or text"`
+}
+
+// Default returns the configuration used for settings that no source sets.
+func Default() Config {
+	return Config{
+		Server: Server{
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    5 * time.Second,
+			IdleTimeout:     5 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+			DrainDelay:      5 * time.Second,
+		},
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxIdleTime: 15 * time.Minute,
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "X-Request-ID"},
+			ExposedHeaders: []string{"ETag", "Retry-After", "X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
+			MaxAge:         10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Backend: "memory",
+		},
+		Articles: Articles{
+			Retention: 30 * 24 * time.Hour,
+		},
+		Password: Password{
+			MinScore: 3,
+		},
+		Mail: Mail{
+			From:     "no-reply@localhost",
+			SMTPPort: 587,
+		},
+		OIDC: OIDC{
+			Scopes: []string{"openid", "profile", "email"},
+		},
+		Tracing: Tracing{
+			ServiceName: "techblog",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// TrustedProxyPrefixes parses the trusted proxies. Single IPs are turned into
+// prefixes holding only that IP.
+func (s Server) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("server.trusted_proxies: %q is not an IP or CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// minJWTSecretLength is the key size of HS256.
+const minJWTSecretLength = 32
+
+// complete fills in settings that default to other settings.
+func (c *Config) complete() {
+	if c.Server.PublicURL == "" && c.Server.Port != "" {
+		c.Server.PublicURL = "http://localhost:" + strings.TrimPrefix(c.Server.Port, ":")
+	}
+
+	// Like database/sql, keep no more idle connections than may be open.
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		c.Database.MaxIdleConns = c.Database.MaxOpenConns
+	}
+
+	if c.OIDC.Issuer != "" && c.OIDC.RedirectURL == "" {
+		c.OIDC.RedirectURL = strings.TrimSuffix(c.Server.PublicURL, "/") + "/api/v1/auth/oidc/callback"
+	}
+}
+
+// ValidateServe reports the settings that are missing to serve requests, in
+// addition to the problems found by Validate. Commands that only need the
+// database, such as migrations, need not set them.
+func (c *Config) ValidateServe() error {
+	var errs []error
+	if c.Server.Port == "" {
+		errs = append(errs, errors.New("server.port is required"))
+	}
+	if len(c.Auth.JWTSecret) < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("auth.jwt_secret must be at least %d bytes", minJWTSecretLength))
+	}
+	return errors.Join(append(errs, c.Validate())...)
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Database.URL != "", "database.url is required")
+
+	if c.Server.PublicURL != "" {
+		u, err := url.Parse(c.Server.PublicURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "server.public_url must be an absolute URL")
+	}
+
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time must not be negative")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			check(!c.CORS.AllowCredentials, "cors.allowed_origins must list origins when cors.allow_credentials is set")
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins: %q is not an origin", origin))
+			continue
+		}
+		if strings.Contains(u.Host, "*") {
+			check(strings.HasPrefix(u.Host, "*.") && strings.Count(u.Host, "*") == 1,
+				"cors.allowed_origins: %q must use the wildcard as the first label, as in https://*.example.com", origin)
+		}
+	}
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.RateLimit.Backend {
+	case "memory", "postgres":
+	default:
+		errs = append(errs, errors.New("rate_limit.backend must be one of memory or postgres"))
+	}
+
+	check(c.Articles.Retention > 0, "articles.retention must be positive")
+
+	check(c.Password.MinScore >= 0 && c.Password.MinScore <= 4, "password.min_score must be between 0 and 4")
+
+	check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "mail.smtp_port must be between 1 and 65535")
+
+	if c.OIDC.Issuer != "" {
+		check(c.OIDC.ClientID != "", "oidc.client_id is required when oidc.issuer is set")
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "stdout", "otlp":
+	default:
+		errs = append(errs, errors.New("tracing.exporter must be one of stdout or otlp"))
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, errors.New("log.level must be one of debug, info, warn or error"))
+	}
+
+	switch c.Log.Format {
+	case "json", "text":
+	default:
+		errs = append(errs, errors.New("log.format must be one of json or text"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a leaf of Config, such as server.read_timeout.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret string
+	value  reflect.Value
+}
+
+// flagName is the command-line flag of the setting.
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+// settings lists the settings of c in declaration order. The values point
+// into c.
+func settings(c *Config) []setting {
+	var out []setting
+
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := sf.Tag.Get("config")
+			if name == "" || name == "-" {
+				continue
+			}
+
+			key := prefix + name
+			if sf.Type.Kind() == reflect.Struct {
+				walk(key+".", v.Field(i))
+				continue
+			}
+
+			out = append(out, setting{
+				key:    key,
+				env:    sf.Tag.Get("env"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+
+	return out
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the config file, the environment and the command-line flags in
+// args. The config file is named by the -config flag or the CONFIG_FILE
+// variable. Arguments after the flags are left in Config.Args. lookupEnv is
+// usually os.LookupEnv.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("techblog", flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", "", "YAML or TOML config file")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+
+	// Flags are only applied after the file and the environment, so their
+	// values are collected first.
+	var flagged []flagValue
+	for _, s := range settings(&cfg) {
+		usage := s.usage
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		fs.Var(&flagValue{setting: s, set: &flagged}, s.flagName(), usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+
+	if cfg.File == "" {
+		cfg.File, _ = lookupEnv("CONFIG_FILE")
+	}
+
+	if cfg.File != "" {
+		if err := cfg.loadFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings(&cfg) {
+		if s.env == "" {
+			continue
+		}
+		if v, ok := lookupEnv(s.env); ok {
+			if err := setString(s.value, v); err != nil {
+				return nil, fmt.Errorf("%s is invalid: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, f := range flagged {
+		if err := setString(f.value, f.raw); err != nil {
+			return nil, fmt.Errorf("-%s is invalid: %w", f.flagName(), err)
+		}
+	}
+
+	cfg.complete()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// flagValue records the value of a setting given on the command line.
+type flagValue struct {
+	setting
+	raw string
+	set *[]flagValue
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(v string) error {
+	// Parse into a scratch value to report mistakes while parsing flags.
+	scratch := reflect.New(f.value.Type()).Elem()
+	if err := setString(scratch, v); err != nil {
+		return err
+	}
+
+	f.raw = v
+	*f.set = append(*f.set, *f)
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as -flag instead of -flag=true.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.value.Kind() == reflect.Bool
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]interface{}{}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .yaml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := map[string]interface{}{}
+	flatten("", raw, values)
+
+	known := map[string]setting{}
+	for _, s := range settings(c) {
+		known[s.key] = s
+	}
+
+	var errs []error
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := known[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %s", key))
+			continue
+		}
+		if err := setFileValue(s.value, values[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s is invalid: %w", key, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// flatten turns nested tables into dotted keys.
+func flatten(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(prefix+k+".", nested, out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+// setFileValue sets v from a value decoded from a YAML or TOML file.
+func setFileValue(v reflect.Value, raw interface{}) error {
+	switch x := raw.(type) {
+	case string:
+		return setString(v, x)
+	case bool:
+		if v.Kind() != reflect.Bool {
+			return fmt.Errorf("expected %s, got a boolean", kindName(v))
+		}
+		v.SetBool(x)
+	case int, int64:
+		if v.Kind() != reflect.Int || v.Type() == reflect.TypeOf(time.Duration(0)) {
+			return fmt.Errorf("expected %s, got a number", kindName(v))
+		}
+		v.SetInt(reflect.ValueOf(x).Int())
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("expected %s, got a list", kindName(v))
+		}
+		items := make([]string, 0, len(x))
+		for _, item := range x {
+			s, ok := item.(string)
+			if !ok {
+				return errors.New("expected a list of strings")
+			}
+			items = append(items, s)
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("expected %s", kindName(v))
+	}
+
+	return nil
+}
+
+// setString sets v from the text of an environment variable or a flag.
+func setString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		items := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+
+	return nil
+}
+
+func kindName(v reflect.Value) string {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		return "a duration such as 5s"
+	case v.Kind() == reflect.Int:
+		return "a number"
+	case v.Kind() == reflect.Bool:
+		return "a boolean"
+	case v.Kind() == reflect.Slice:
+		return "a list"
+	default:
+		return "a string"
+	}
+}
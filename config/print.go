@@ -0,0 +1,100 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Print writes the configuration as YAML, in the format of the config file,
+// with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+
+	for _, s := range settings(c) {
+		section, name := "", s.key
+		if i := strings.LastIndex(s.key, "."); i >= 0 {
+			section, name = s.key[:i], s.key[i+1:]
+		}
+
+		parent, ok := sections[section]
+		if !ok {
+			parent = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = parent
+			root.Content = append(root.Content, scalar(section), parent)
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(printable(s)); err != nil {
+			return err
+		}
+		parent.Content = append(parent.Content, scalar(name), value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func scalar(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: s}
+}
+
+// printable returns the value of s as it should be shown.
+func printable(s setting) interface{} {
+	if d, ok := s.value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	if s.value.Kind() == reflect.String && s.value.String() != "" {
+		switch s.secret {
+		case "true":
+			return redacted
+		case "url":
+			return redactURL(s.value.String())
+		}
+	}
+
+	return s.value.Interface()
+}
+
+// dsnPassword matches the password of a keyword/value connection string such
+// as "host=db password=secret", which may be quoted.
+var dsnPassword = regexp.MustCompile(`(^|\s)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactURL hides the password of a connection URL or a keyword/value
+// connection string but keeps the rest, which is useful to see which database
+// is used. Values that are neither are hidden completely.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		if !strings.Contains(raw, "=") {
+			return redacted
+		}
+		return dsnPassword.ReplaceAllString(raw, "${1}${2}"+redacted)
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	q := u.Query()
+	if q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+
+	// The userinfo is percent-encoded, which would garble the marker.
+	return strings.ReplaceAll(u.String(), url.QueryEscape(redacted), redacted)
+}
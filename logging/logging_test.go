@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestNew_requestID(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger, err := New(&buf, Config{Level: "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1234")
+
+	logger.InfoContext(ctx, "dropped")
+	logger.ErrorContext(ctx, "kept", "error", "boom")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line at level warn, but got %d: %s", len(lines), buf.String())
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(lines[0], &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if record["msg"] != "kept" || record["request_id"] != "req-1234" || record["error"] != "boom" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestNew_invalidConfig(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Config{Level: "loud"}); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+
+	if _, err := New(&bytes.Buffer{}, Config{Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
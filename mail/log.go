@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+var _ Mailer = (*LogMailer)(nil)
+
+// LogMailer writes messages to w instead of delivering them. It is meant for
+// local development and tests.
+type LogMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{w: w, from: from}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.w.Write(format(m.from, msg, time.Now())); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(m.w, "\r\n\r\n")
+	return err
+}
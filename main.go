@@ -1,46 +1,273 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/msksgm/go-techblog-msksgm/config"
+	"github.com/msksgm/go-techblog-msksgm/logging"
+	"github.com/msksgm/go-techblog-msksgm/mail"
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/oidc"
+	"github.com/msksgm/go-techblog-msksgm/password"
 	"github.com/msksgm/go-techblog-msksgm/postgres"
 	"github.com/msksgm/go-techblog-msksgm/server"
+	"github.com/msksgm/go-techblog-msksgm/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-type config struct {
-	port  string
-	dbURI string
+func main() {
+	err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(os.Stderr, usageErr)
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("command failed", "error", err)
+		os.Exit(1)
+	}
 }
 
-func main() {
-	cfg, err := envConfig()
+const usage = `usage: techblog <command> [flags] [arguments]
+
+commands:
+  serve     serve the API, the default when no command is given
+  migrate   apply or inspect database migrations
+  user      create, list and manage users
+  article   list, unpublish and reassign articles
+  token     revoke personal access tokens
+
+Run techblog <command> -h for the flags of a command.`
+
+// usageError is returned for invalid command lines. It is printed as is,
+// without the logger.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func run(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serve(args)
+	}
+
+	command, rest := args[0], args[1:]
+	switch command {
+	case "serve":
+		return serve(rest)
+	case "migrate":
+		return migrate(rest)
+	case "user":
+		return user(rest)
+	case "article":
+		return article(rest)
+	case "token":
+		return token(rest)
+	case "help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return usageError(fmt.Sprintf("unknown command %q\n\n%s", command, usage))
+	}
+}
+
+// loadConfig loads the configuration and sets up logging for a command.
+// Commands that print results log to stderr to keep stdout for them.
+func loadConfig(args []string, logOutput io.Writer) (*config.Config, error) {
+	cfg, err := config.Load(args, os.LookupEnv)
 	if err != nil {
-		log.Fatalln("err:", err)
+		return nil, err
 	}
 
-	db, err := postgres.Open(cfg.dbURI)
+	logger, err := logging.New(logOutput, logging.Config{Level: cfg.Log.Level, Format: cfg.Log.Format})
 	if err != nil {
-		log.Fatalln("err:", err)
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	if cfg.File != "" {
+		slog.Info("loaded config file", "path", cfg.File)
+	}
+
+	return cfg, nil
+}
+
+func openDB(cfg *config.Config) (*postgres.DB, error) {
+	return postgres.Open(cfg.Database.URL, postgres.PoolOptions{
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.Database.ConnMaxIdleTime,
+	})
+}
+
+// serve starts the server and blocks until it has shut down after SIGINT or
+// SIGTERM. The database is closed last, once no request or worker uses it.
+func serve(args []string) error {
+	cfg, err := loadConfig(args, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Args) > 0 {
+		return fmt.Errorf("unknown command %q", cfg.Args[0])
+	}
+
+	if cfg.PrintConfig {
+		return cfg.Print(os.Stdout)
 	}
 
-	srv := server.NewServer(db)
-	if err := srv.Run(cfg.port); err != nil {
-		log.Fatalln("err:", err)
+	if err := cfg.ValidateServe(); err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: cfg.Tracing.ServiceName,
+		Exporter:    cfg.Tracing.Exporter,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("error flushing traces", "error", err)
+		}
+	}()
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("error closing database", "error", err)
+		}
+	}()
+
+	if err := checkSchema(db, cfg.Database.AutoMigrate); err != nil {
+		return err
+	}
+
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		return err
+	}
+
+	policy, err := passwordPolicy(cfg.Password)
+	if err != nil {
+		return err
+	}
+
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		client := &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		}
+		provider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		}, client)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	trustedProxies, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return err
+	}
+
+	var rateLimiter model.RateLimiter
+	if cfg.RateLimit.Backend == "postgres" {
+		rateLimiter = postgres.NewRateLimiter(db)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := server.NewServer(db, server.Config{
+		JWTSecret:            []byte(cfg.Auth.JWTSecret),
+		Mailer:               mailer,
+		PublicURL:            cfg.Server.PublicURL,
+		RequireVerifiedEmail: cfg.Features.RequireVerifiedEmail,
+		PasswordPolicy:       policy,
+		OIDC:                 provider,
+		ShutdownTimeout:      cfg.Server.ShutdownTimeout,
+		DrainDelay:           cfg.Server.DrainDelay,
+		ReadTimeout:          cfg.Server.ReadTimeout,
+		WriteTimeout:         cfg.Server.WriteTimeout,
+		IdleTimeout:          cfg.Server.IdleTimeout,
+		RateLimiter:          rateLimiter,
+		DisableRateLimit:     !cfg.RateLimit.Enabled,
+		TrustedProxies:       trustedProxies,
+		CORS: server.CORSConfig{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.CORS.ExposedHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		},
+	})
+	srv.StartArticlePurger(ctx, cfg.Articles.Retention)
+
+	if err := srv.Run(ctx, cfg.Server.Port); err != nil {
+		return err
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
+
+func passwordPolicy(cfg config.Password) (*password.Policy, error) {
+	policy := &password.Policy{MinScore: cfg.MinScore}
+	if cfg.BreachedFile != "" {
+		var err error
+		policy.Breached, err = password.LoadHashListFile(cfg.BreachedFile)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return policy, nil
 }
 
-func envConfig() (config, error) {
-	port, ok := os.LookupEnv("PORT")
-	if !ok {
-		return config{}, fmt.Errorf("PORT is not provided")
+// newMailer delivers through SMTP when an SMTP host is configured and
+// otherwise writes messages to the mail file or stdout.
+func newMailer(cfg config.Mail) (mail.Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
 	}
 
-	dbURI, ok := os.LookupEnv("POSTGRESQL_URL")
-	if !ok {
-		return config{}, fmt.Errorf("POSTGRESQL_URL is not provided")
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return mail.NewLogMailer(f, cfg.From), nil
 	}
 
-	return config{port: port, dbURI: dbURI}, nil
+	return mail.NewLogMailer(os.Stdout, cfg.From), nil
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_run_usage(t *testing.T) {
+	// Usage errors are returned before the database is opened.
+	t.Setenv("POSTGRESQL_URL", "postgres://localhost/techblog")
+
+	tests := []struct {
+		name string
+		args []string
+		want error
+	}{
+		{"unknown command", []string{"bogus"}, nil},
+		{"user without action", []string{"user"}, errUserUsage},
+		{"user with unknown action", []string{"user", "bogus"}, errUserUsage},
+		{"article without action", []string{"article"}, errArticleUsage},
+		{"token with unknown action", []string{"token", "bogus"}, errTokenUsage},
+		{"migrate without action", []string{"migrate"}, errMigrateUsage},
+		{"migrate to without version", []string{"migrate", "to"}, errMigrateUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(tt.args)
+
+			var usageErr usageError
+			if !errors.As(err, &usageErr) {
+				t.Fatalf("expected a usage error, but got %v", err)
+			}
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("expected %q, but got %q", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/msksgm/go-techblog-msksgm/postgres"
+)
+
+var errMigrateUsage = usageError("usage: techblog migrate [flags] up | down | status | to <version> | force <version>")
+
+// migrate applies the embedded migrations to the database.
+func migrate(args []string) error {
+	cfg, err := loadConfig(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Args) == 0 {
+		return errMigrateUsage
+	}
+
+	action, rest := cfg.Args[0], cfg.Args[1:]
+
+	var version uint
+	switch action {
+	case "up", "down", "status":
+		if len(rest) != 0 {
+			return errMigrateUsage
+		}
+	case "to", "force":
+		if len(rest) != 1 {
+			return errMigrateUsage
+		}
+		v, err := strconv.ParseUint(rest[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", rest[0])
+		}
+		version = uint(v)
+	default:
+		return errMigrateUsage
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch action {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		err = migrator.To(ctx, version)
+	case "force":
+		err = migrator.Force(ctx, version)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printMigrationStatus(status)
+}
+
+func printMigrationStatus(status *postgres.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "current version:\t%d\n", status.Current)
+	fmt.Fprintf(w, "latest version:\t%d\n", status.Latest)
+	if status.Dirty {
+		fmt.Fprintf(w, "dirty:\tyes, repair the schema and run migrate force <version>\n")
+	}
+	for _, m := range status.Pending {
+		fmt.Fprintf(w, "pending:\t%06d_%s\n", m.Version, m.Name)
+	}
+
+	return w.Flush()
+}
+
+// checkSchema refuses to serve a database that this binary does not
+// understand. It applies pending migrations first if autoMigrate is set.
+func checkSchema(db *postgres.DB, autoMigrate bool) error {
+	ctx := context.Background()
+
+	migrator, err := postgres.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		if err := migrator.Up(ctx); err != nil {
+			return fmt.Errorf("cannot migrate database: %w", err)
+		}
+	}
+
+	status, err := migrator.CheckSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(status.Pending) > 0 {
+		slog.Warn("database schema is behind, run techblog migrate up",
+			"version", status.Current, "latest", status.Latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+type PersonalAccessTokenService struct {
+	CreatePersonalAccessTokenFn       func(*model.PersonalAccessToken) error
+	PersonalAccessTokensFn            func(model.PersonalAccessTokenFilter) ([]*model.PersonalAccessToken, error)
+	AuthenticatePersonalAccessTokenFn func(string) (*model.PersonalAccessToken, *model.User, error)
+	RevokePersonalAccessTokenFn       func(uint) error
+}
+
+func (m *PersonalAccessTokenService) CreatePersonalAccessToken(_ context.Context, token *model.PersonalAccessToken) error {
+	return m.CreatePersonalAccessTokenFn(token)
+}
+
+func (m *PersonalAccessTokenService) PersonalAccessTokens(_ context.Context, filter model.PersonalAccessTokenFilter) ([]*model.PersonalAccessToken, error) {
+	return m.PersonalAccessTokensFn(filter)
+}
+
+func (m *PersonalAccessTokenService) AuthenticatePersonalAccessToken(_ context.Context, plaintext string) (*model.PersonalAccessToken, *model.User, error) {
+	return m.AuthenticatePersonalAccessTokenFn(plaintext)
+}
+
+func (m *PersonalAccessTokenService) RevokePersonalAccessToken(_ context.Context, id uint) error {
+	return m.RevokePersonalAccessTokenFn(id)
+}
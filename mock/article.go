@@ -2,16 +2,25 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/msksgm/go-techblog-msksgm/model"
 )
 
 type ArticleService struct {
-	CreateArticleFn func(*model.Article) error
-	ArticleBySlugFn func() (*model.Article, error)
-	ArticlesFn      func() ([]*model.Article, error)
-	DeleteArticleFn func() error
-	UpdateArticleFn func(*model.Article) error
+	CreateArticleFn  func(*model.Article) error
+	ArticleBySlugFn  func() (*model.Article, error)
+	ArticlesFn       func() ([]*model.Article, error)
+	DeleteArticleFn  func() error
+	UpdateArticleFn  func(*model.Article) error
+	RestoreArticleFn func() error
+	PurgeArticlesFn  func(time.Time) (int64, error)
+
+	InviteCoAuthorFn           func(articleID, userID uint) error
+	AcceptCoAuthorInvitationFn func(articleID, userID uint) error
+	RemoveCoAuthorFn           func(articleID, userID uint) error
+	ReassignArticleFn          func(articleID, userID uint) error
+	RelatedArticlesFn          func(articleID uint, limit int) ([]*model.Article, error)
 }
 
 func (m *ArticleService) CreateArticle(_ context.Context, article *model.Article) error {
@@ -26,10 +35,38 @@ func (m *ArticleService) ArticleBySlug(_ context.Context, slug string) (*model.A
 	return m.ArticleBySlugFn()
 }
 
-func (m *ArticleService) DeleteArticle(_ context.Context, id uint) error {
+func (m *ArticleService) DeleteArticle(_ context.Context, article *model.Article) error {
 	return m.DeleteArticleFn()
 }
 
 func (m *ArticleService) UpdateArticle(_ context.Context, article *model.Article, patch model.ArticlePatch) error {
 	return m.UpdateArticleFn(article)
 }
+
+func (m *ArticleService) RestoreArticle(_ context.Context, id uint) error {
+	return m.RestoreArticleFn()
+}
+
+func (m *ArticleService) PurgeArticles(_ context.Context, deletedBefore time.Time) (int64, error) {
+	return m.PurgeArticlesFn(deletedBefore)
+}
+
+func (m *ArticleService) InviteCoAuthor(_ context.Context, articleID, userID uint) error {
+	return m.InviteCoAuthorFn(articleID, userID)
+}
+
+func (m *ArticleService) AcceptCoAuthorInvitation(_ context.Context, articleID, userID uint) error {
+	return m.AcceptCoAuthorInvitationFn(articleID, userID)
+}
+
+func (m *ArticleService) RemoveCoAuthor(_ context.Context, articleID, userID uint) error {
+	return m.RemoveCoAuthorFn(articleID, userID)
+}
+
+func (m *ArticleService) ReassignArticle(_ context.Context, articleID, userID uint) error {
+	return m.ReassignArticleFn(articleID, userID)
+}
+
+func (m *ArticleService) RelatedArticles(_ context.Context, articleID uint, limit int) ([]*model.Article, error) {
+	return m.RelatedArticlesFn(articleID, limit)
+}
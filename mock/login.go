@@ -0,0 +1,36 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+type LoginAttemptService struct {
+	RecordLoginAttemptFn  func(*model.LoginAttempt) error
+	SucceedLoginAttemptFn func(uint) error
+	DeleteLoginAttemptFn  func(uint) error
+	LoginFailuresFn       func(model.LoginAttemptFilter) (model.LoginFailures, error)
+	PurgeLoginAttemptsFn  func(time.Time) (int64, error)
+}
+
+func (m *LoginAttemptService) RecordLoginAttempt(_ context.Context, attempt *model.LoginAttempt) error {
+	return m.RecordLoginAttemptFn(attempt)
+}
+
+func (m *LoginAttemptService) SucceedLoginAttempt(_ context.Context, id uint) error {
+	return m.SucceedLoginAttemptFn(id)
+}
+
+func (m *LoginAttemptService) DeleteLoginAttempt(_ context.Context, id uint) error {
+	return m.DeleteLoginAttemptFn(id)
+}
+
+func (m *LoginAttemptService) LoginFailures(_ context.Context, filter model.LoginAttemptFilter) (model.LoginFailures, error) {
+	return m.LoginFailuresFn(filter)
+}
+
+func (m *LoginAttemptService) PurgeLoginAttempts(_ context.Context, before time.Time) (int64, error) {
+	return m.PurgeLoginAttemptsFn(before)
+}
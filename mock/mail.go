@@ -0,0 +1,15 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/msksgm/go-techblog-msksgm/mail"
+)
+
+type Mailer struct {
+	SendFn func(mail.Message) error
+}
+
+func (m *Mailer) Send(_ context.Context, msg mail.Message) error {
+	return m.SendFn(msg)
+}
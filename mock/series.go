@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+type SeriesService struct {
+	CreateSeriesFn func(*model.Series, []uint) error
+	SeriesBySlugFn func(string) (*model.Series, error)
+	SeriesFn       func(model.SeriesFilter) ([]*model.Series, error)
+	UpdateSeriesFn func(*model.Series, model.SeriesPatch) error
+	DeleteSeriesFn func(uint) error
+}
+
+func (m *SeriesService) CreateSeries(_ context.Context, series *model.Series, articleIDs []uint) error {
+	return m.CreateSeriesFn(series, articleIDs)
+}
+
+func (m *SeriesService) SeriesBySlug(_ context.Context, slug string) (*model.Series, error) {
+	return m.SeriesBySlugFn(slug)
+}
+
+func (m *SeriesService) Series(_ context.Context, filter model.SeriesFilter) ([]*model.Series, error) {
+	return m.SeriesFn(filter)
+}
+
+func (m *SeriesService) UpdateSeries(_ context.Context, series *model.Series, patch model.SeriesPatch) error {
+	return m.UpdateSeriesFn(series, patch)
+}
+
+func (m *SeriesService) DeleteSeries(_ context.Context, id uint) error {
+	return m.DeleteSeriesFn(id)
+}
@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+type TokenService struct {
+	CreateTokenFn         func(*model.Token) error
+	ConsumeTokenFn        func(scope, plaintext string) (*model.User, error)
+	TokenUserFn           func(scope, plaintext string) (*model.User, error)
+	DeleteTokensForUserFn func(scope string, userID uint) error
+	ResetPasswordFn       func(plaintext, passwordHash string) (*model.User, error)
+}
+
+func (m *TokenService) CreateToken(_ context.Context, token *model.Token) error {
+	return m.CreateTokenFn(token)
+}
+
+func (m *TokenService) ConsumeToken(_ context.Context, scope, plaintext string) (*model.User, error) {
+	return m.ConsumeTokenFn(scope, plaintext)
+}
+
+func (m *TokenService) TokenUser(_ context.Context, scope, plaintext string) (*model.User, error) {
+	return m.TokenUserFn(scope, plaintext)
+}
+
+func (m *TokenService) DeleteTokensForUser(_ context.Context, scope string, userID uint) error {
+	return m.DeleteTokensForUserFn(scope, userID)
+}
+
+func (m *TokenService) ResetPassword(_ context.Context, plaintext, passwordHash string) (*model.User, error) {
+	return m.ResetPasswordFn(plaintext, passwordHash)
+}
@@ -7,10 +7,22 @@ import (
 )
 
 type UserService struct {
-	CreateUserFn     func(*model.User) error
-	AuthenticateFn   func() *model.User
-	GetCurrentUserFn func() *model.User
-	UpdateUserFn     func(*model.User, model.UserPatch) error
+	CreateUserFn             func(*model.User) error
+	AuthenticateFn           func() *model.User
+	GetCurrentUserFn         func() *model.User
+	UserByUsernameFn         func(string) (*model.User, error)
+	UsersFn                  func(model.UserFilter) ([]*model.User, error)
+	UserByPreviousUsernameFn func(string) (*model.User, error)
+	UserByEmailFn            func(string) (*model.User, error)
+	UserByIdentityFn         func(issuer, subject string) (*model.User, error)
+	CreateUserWithIdentityFn func(*model.User, *model.Identity) error
+	UpdateUserFn             func(*model.User, model.UserPatch) error
+	SetPasswordFn            func(*model.User, string) error
+	DeleteUserFn             func(uint, model.AuthoredContentPolicy) error
+	EnableTwoFactorFn        func(uint, [][]byte) error
+	UseRecoveryCodeFn        func(uint, []byte) error
+	UseTOTPStepFn            func(uint, int64) error
+	ExportUserFn             func(uint) (*model.UserExport, error)
 }
 
 func (m *UserService) CreateUser(_ context.Context, user *model.User) error {
@@ -22,9 +34,56 @@ func (m *UserService) Authenticate(_ context.Context, username, password string)
 }
 
 func (m *UserService) UserByUsername(_ context.Context, username string) (*model.User, error) {
+	if m.UserByUsernameFn != nil {
+		return m.UserByUsernameFn(username)
+	}
 	return m.GetCurrentUserFn(), nil
 }
 
+func (m *UserService) Users(_ context.Context, filter model.UserFilter) ([]*model.User, error) {
+	return m.UsersFn(filter)
+}
+
+func (m *UserService) UserByPreviousUsername(_ context.Context, username string) (*model.User, error) {
+	return m.UserByPreviousUsernameFn(username)
+}
+
+func (m *UserService) UserByEmail(_ context.Context, email string) (*model.User, error) {
+	return m.UserByEmailFn(email)
+}
+
+func (m *UserService) UserByIdentity(_ context.Context, issuer, subject string) (*model.User, error) {
+	return m.UserByIdentityFn(issuer, subject)
+}
+
+func (m *UserService) CreateUserWithIdentity(_ context.Context, user *model.User, identity *model.Identity) error {
+	return m.CreateUserWithIdentityFn(user, identity)
+}
+
 func (m *UserService) UpdateUser(_ context.Context, user *model.User, patch model.UserPatch) error {
 	return m.UpdateUserFn(user, patch)
 }
+
+func (m *UserService) DeleteUser(_ context.Context, id uint, policy model.AuthoredContentPolicy) error {
+	return m.DeleteUserFn(id, policy)
+}
+
+func (m *UserService) EnableTwoFactor(_ context.Context, userID uint, recoveryCodeHashes [][]byte) error {
+	return m.EnableTwoFactorFn(userID, recoveryCodeHashes)
+}
+
+func (m *UserService) UseRecoveryCode(_ context.Context, userID uint, hash []byte) error {
+	return m.UseRecoveryCodeFn(userID, hash)
+}
+
+func (m *UserService) UseTOTPStep(_ context.Context, userID uint, step int64) error {
+	return m.UseTOTPStepFn(userID, step)
+}
+
+func (m *UserService) ExportUser(_ context.Context, userID uint) (*model.UserExport, error) {
+	return m.ExportUserFn(userID)
+}
+
+func (m *UserService) SetPassword(_ context.Context, user *model.User, passwordHash string) error {
+	return m.SetPasswordFn(user, passwordHash)
+}
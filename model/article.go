@@ -2,26 +2,68 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
 type Article struct {
-	ID        uint      `json:"-"`
-	Title     string    `json:"title"`
-	Body      string    `json:"body"`
-	Slug      string    `json:"slug"`
-	AuthorID  uint      `json:"-" db:"author_id"`
-	Author    *User     `json:"-"`
-	CreatedAt time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	ID        uint              `json:"-"`
+	Title     string            `json:"title"`
+	Body      string            `json:"body"`
+	Slug      string            `json:"slug"`
+	AuthorID  uint              `json:"-" db:"author_id"`
+	Author    *User             `json:"-"`
+	Authors   []*ArticleAuthor  `json:"authors,omitempty" db:"-"`
+	Series    *SeriesNavigation `json:"series,omitempty" db:"-"`
+	Version   uint              `json:"-"`
+	CreatedAt time.Time         `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time         `json:"updatedAt" db:"updated_at"`
+	DeletedAt *time.Time        `json:"deletedAt,omitempty" db:"deleted_at"`
+}
+
+// ETag returns the entity tag identifying the current revision of the article.
+func (a *Article) ETag() string {
+	return fmt.Sprintf(`"%d"`, a.Version)
+}
+
+// IsAuthor reports whether userID is one of the accepted authors. Articles
+// whose authors were not loaded only know their primary author.
+func (a *Article) IsAuthor(userID uint) bool {
+	if a.AuthorID == userID {
+		return true
+	}
+	for _, author := range a.Authors {
+		if author.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ArticleAuthor credits a user on an article. The primary author has position
+// 0 and co-authors follow in the order they were invited. Invited co-authors
+// are only credited once they accept.
+type ArticleAuthor struct {
+	ArticleID  uint       `json:"-" db:"article_id"`
+	UserID     uint       `json:"-" db:"user_id"`
+	Username   string     `json:"username"`
+	Position   int        `json:"-"`
+	InvitedAt  time.Time  `json:"-" db:"invited_at"`
+	AcceptedAt *time.Time `json:"-" db:"accepted_at"`
 }
 
 type ArticleFilter struct {
-	ID             *uint
-	Title          *string
-	AuthorID       *uint
+	ID       *uint
+	Title    *string
+	AuthorID *uint
+	// AuthorUsername matches articles the user is an accepted author of.
 	AuthorUsername *string
-	Slug           *string
+	// InvitedUserID matches articles the user was invited to co-author but
+	// has not accepted yet.
+	InvitedUserID *uint
+	Slug          *string
+	// Trashed selects soft-deleted articles instead of live ones.
+	Trashed bool
 
 	Limit  int
 	Offset int
@@ -33,10 +75,39 @@ type ArticlePatch struct {
 	Slug  *string
 }
 
+// MaxRelatedArticles is the most related articles that are recommended for
+// an article.
+const MaxRelatedArticles = 20
+
 type ArticleService interface {
+	// CreateArticle returns ErrDuplicateSlug if a live article has the slug.
 	CreateArticle(context.Context, *Article) error
 	ArticleBySlug(context.Context, string) (*Article, error)
 	Articles(context.Context, ArticleFilter) ([]*Article, error)
 	UpdateArticle(context.Context, *Article, ArticlePatch) error
-	DeleteArticle(context.Context, uint) error
+	// DeleteArticle moves the article to the trash. It returns
+	// ErrEditConflict if the article was changed since article.Version.
+	DeleteArticle(context.Context, *Article) error
+	// RestoreArticle returns ErrDuplicateSlug if the slug was taken by
+	// another article while the article was in the trash.
+	RestoreArticle(context.Context, uint) error
+	PurgeArticles(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	// InviteCoAuthor invites userID to co-author the article. It returns
+	// ErrDuplicateAuthor if the user is already an author or invited.
+	InviteCoAuthor(ctx context.Context, articleID, userID uint) error
+	// AcceptCoAuthorInvitation returns ErrNotFound if userID has no pending
+	// invitation to the article.
+	AcceptCoAuthorInvitation(ctx context.Context, articleID, userID uint) error
+	// RemoveCoAuthor removes a co-author or withdraws an invitation. The
+	// primary author cannot be removed.
+	RemoveCoAuthor(ctx context.Context, articleID, userID uint) error
+	// ReassignArticle makes userID the primary author of the article. A
+	// co-authorship or invitation of the user is folded into it and the
+	// previous primary author loses their credit.
+	ReassignArticle(ctx context.Context, articleID, userID uint) error
+
+	// RelatedArticles returns up to limit live articles that are similar to
+	// the article, the most similar first.
+	RelatedArticles(ctx context.Context, articleID uint, limit int) ([]*Article, error)
 }
@@ -4,7 +4,12 @@ import "errors"
 
 var (
 	ErrDuplicateUsername = errors.New("duplicate username")
+	ErrDuplicateEmail    = errors.New("duplicate email")
 	ErrUnAuthorized      = errors.New("unauthorized")
 	ErrNotFound          = errors.New("record not found")
+	ErrEditConflict      = errors.New("edit conflict")
+	ErrDuplicateAuthor   = errors.New("duplicate author")
+	ErrDuplicateSlug     = errors.New("duplicate slug")
+	ErrArticleInSeries   = errors.New("article already belongs to a series")
 	ErrInternal          = errors.New("internal error")
 )
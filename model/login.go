@@ -0,0 +1,44 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// LoginAttempt records a single call to the login endpoint so that repeated
+// failures can be throttled and reviewed by administrators.
+type LoginAttempt struct {
+	ID        uint      `json:"-"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type LoginAttemptFilter struct {
+	Username *string
+	IP       *string
+	Since    time.Time
+	// SinceLastSuccess ignores failures that precede the most recent
+	// successful login for Username.
+	SinceLastSuccess bool
+	// ExceptID leaves out the attempt being made, which is recorded before
+	// the credentials are checked.
+	ExceptID uint
+}
+
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+type LoginAttemptService interface {
+	RecordLoginAttempt(context.Context, *LoginAttempt) error
+	// SucceedLoginAttempt marks the recorded attempt as successful.
+	SucceedLoginAttempt(ctx context.Context, id uint) error
+	DeleteLoginAttempt(ctx context.Context, id uint) error
+	LoginFailures(context.Context, LoginAttemptFilter) (LoginFailures, error)
+	// PurgeLoginAttempts deletes attempts made before the given time and
+	// returns how many were deleted.
+	PurgeLoginAttempts(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords with one algorithm. Encoded hashes carry an
+// algorithm prefix such as "$argon2id$" or "$2a$" so that hashes written by
+// different hashers can live side by side.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+
+	// Verify reports whether password matches encoded.
+	Verify(encoded, password string) bool
+
+	// Recognizes reports whether encoded was produced by this algorithm,
+	// regardless of its parameters.
+	Recognizes(encoded string) bool
+
+	// Outdated reports whether encoded was produced with weaker parameters
+	// than the hasher is configured with.
+	Outdated(encoded string) bool
+}
+
+// PasswordHashing hashes new passwords with Preferred and still verifies
+// hashes written by any of Legacy.
+type PasswordHashing struct {
+	Preferred PasswordHasher
+	Legacy    []PasswordHasher
+}
+
+// DefaultPasswordHashing is used by User.SetPassword and User.VerifyPassword.
+var DefaultPasswordHashing = PasswordHashing{
+	Preferred: Argon2idHasher{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+	Legacy:    []PasswordHasher{BcryptHasher{Cost: bcrypt.DefaultCost}},
+}
+
+func (p PasswordHashing) Hash(password string) (string, error) {
+	return p.Preferred.Hash(password)
+}
+
+func (p PasswordHashing) Verify(encoded, password string) bool {
+	hasher := p.hasherFor(encoded)
+	if hasher == nil {
+		return false
+	}
+	return hasher.Verify(encoded, password)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a hash from the
+// preferred hasher, either because it uses another algorithm or weaker
+// parameters.
+func (p PasswordHashing) NeedsRehash(encoded string) bool {
+	if !p.Preferred.Recognizes(encoded) {
+		return true
+	}
+	return p.Preferred.Outdated(encoded)
+}
+
+func (p PasswordHashing) hasherFor(encoded string) PasswordHasher {
+	if p.Preferred.Recognizes(encoded) {
+		return p.Preferred
+	}
+	for _, hasher := range p.Legacy {
+		if hasher.Recognizes(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// BcryptHasher hashes passwords with bcrypt. bcrypt only looks at the first 72
+// bytes of a password and refuses to hash longer ones.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashBytes), nil
+}
+
+func (h BcryptHasher) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2idHasher hashes passwords with Argon2id and encodes them in the PHC
+// string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// argon2idMemoryBudget bounds the memory in KiB that concurrent Argon2id
+// computations use together. At 64 MiB each, four run at once and the rest
+// wait, so a burst of logins queues instead of exhausting the process.
+const argon2idMemoryBudget = 256 * 1024
+
+var argon2idMemory = newMemoryLimiter(argon2idMemoryBudget)
+
+var errInvalidArgon2idHash = errors.New("invalid argon2id hash")
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	reserved := argon2idMemory.acquire(h.Memory)
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	argon2idMemory.release(reserved)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Verify(encoded, password string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+
+	reserved := argon2idMemory.acquire(params.Memory)
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	argon2idMemory.release(reserved)
+
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (h Argon2idHasher) Outdated(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		uint32(len(salt)) < h.SaltLength ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	return params, salt, key, nil
+}
+
+// memoryLimiter hands out a fixed budget of memory to callers that wait until
+// their share fits.
+type memoryLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	budget uint32
+	used   uint32
+}
+
+func newMemoryLimiter(budget uint32) *memoryLimiter {
+	l := &memoryLimiter{budget: budget}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until n fits into the budget and returns the amount to
+// release. Requests larger than the whole budget wait for all of it.
+func (l *memoryLimiter) acquire(n uint32) uint32 {
+	if n > l.budget {
+		n = l.budget
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.used+n > l.budget {
+		l.cond.Wait()
+	}
+	l.used += n
+
+	return n
+}
+
+func (l *memoryLimiter) release(n uint32) {
+	l.mu.Lock()
+	l.used -= n
+	l.mu.Unlock()
+
+	l.cond.Broadcast()
+}
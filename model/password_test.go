@@ -0,0 +1,99 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testPasswordHashing() PasswordHashing {
+	return PasswordHashing{
+		Preferred: Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Legacy:    []PasswordHasher{BcryptHasher{Cost: bcrypt.MinCost}},
+	}
+}
+
+func TestPasswordHashing_Argon2id(t *testing.T) {
+	hashing := testPasswordHashing()
+
+	encoded, err := hashing.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("expected a PHC encoded argon2id hash, but got %q", encoded)
+	}
+
+	if !hashing.Verify(encoded, "password") {
+		t.Error("expected the password to match")
+	}
+
+	if hashing.Verify(encoded, "wrong password") {
+		t.Error("expected a wrong password not to match")
+	}
+
+	if hashing.NeedsRehash(encoded) {
+		t.Error("expected a current hash not to need a rehash")
+	}
+}
+
+func TestPasswordHashing_NeedsRehash(t *testing.T) {
+	hashing := testPasswordHashing()
+
+	legacy, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weak, err := Argon2idHasher{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, encoded := range map[string]string{"bcrypt": legacy, "weak argon2id": weak} {
+		if !hashing.Verify(encoded, "password") {
+			t.Errorf("%s: expected the password to match", name)
+		}
+
+		if !hashing.NeedsRehash(encoded) {
+			t.Errorf("%s: expected the hash to need a rehash", name)
+		}
+	}
+
+	if hashing.Verify("!", "password") {
+		t.Error("expected an unknown hash format never to match")
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	l := newMemoryLimiter(100)
+
+	first := l.acquire(60)
+
+	acquired := make(chan uint32)
+	go func() {
+		acquired <- l.acquire(60)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected the second caller to wait while the budget is used up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.release(first)
+
+	select {
+	case second := <-acquired:
+		l.release(second)
+	case <-time.After(time.Second):
+		t.Fatal("expected the second caller to proceed once memory was released")
+	}
+
+	if n := l.acquire(500); n != 100 {
+		t.Errorf("expected a request over budget to reserve the whole budget, but got %d", n)
+	}
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RateLimit is a token bucket holding up to Limit requests, refilled evenly so
+// that Limit more requests are allowed every Period.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitBucket is the state of a client's bucket. The zero value is a full
+// bucket.
+type RateLimitBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next request is allowed, zero if
+	// Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Take refills the bucket up to now and takes a token from it if there is
+// one. It returns the new state of the bucket.
+func (l RateLimit) Take(b RateLimitBucket, now time.Time) (RateLimitBucket, RateLimitResult) {
+	perSecond := float64(l.Limit) / l.Period.Seconds()
+
+	tokens := float64(l.Limit)
+	if !b.UpdatedAt.IsZero() {
+		tokens = math.Min(tokens, b.Tokens+now.Sub(b.UpdatedAt).Seconds()*perSecond)
+	}
+
+	result := RateLimitResult{Limit: l.Limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((float64(l.Limit) - tokens) / perSecond)
+
+	return RateLimitBucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+type RateLimiter interface {
+	// Allow takes a token from the bucket named key, which is created full
+	// if it does not exist yet.
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit_Take(t *testing.T) {
+	limit := RateLimit{Limit: 3, Period: 3 * time.Second}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var bucket RateLimitBucket
+	var result RateLimitResult
+	for i := 0; i < 3; i++ {
+		bucket, result = limit.Take(bucket, now)
+		if !result.Allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+		if want := 2 - i; result.Remaining != want {
+			t.Errorf("expected %d remaining, but got %d", want, result.Remaining)
+		}
+	}
+
+	bucket, result = limit.Take(bucket, now)
+	if result.Allowed {
+		t.Fatal("expected the fourth request to be refused")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("expected to retry after 1s, but got %s", result.RetryAfter)
+	}
+	if result.Reset != 3*time.Second {
+		t.Errorf("expected the bucket to be full after 3s, but got %s", result.Reset)
+	}
+
+	bucket, result = limit.Take(bucket, now.Add(1500*time.Millisecond))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected one token to have been refilled, but got %+v", result)
+	}
+
+	_, result = limit.Take(bucket, now.Add(time.Hour))
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("expected the bucket to refill up to the limit, but got %+v", result)
+	}
+}
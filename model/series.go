@@ -0,0 +1,97 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// Series collects articles that are meant to be read in order, such as the
+// parts of a multi-part deep dive.
+type Series struct {
+	ID          uint             `json:"-"`
+	Title       string           `json:"title"`
+	Slug        string           `json:"slug"`
+	Description string           `json:"description"`
+	AuthorID    uint             `json:"-" db:"author_id"`
+	Articles    []*SeriesArticle `json:"articles" db:"-"`
+	CreatedAt   time.Time        `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time        `json:"updatedAt" db:"updated_at"`
+}
+
+// SeriesArticle is a member of a series. Positions start at 1.
+type SeriesArticle struct {
+	SeriesID  uint   `json:"-" db:"series_id"`
+	ArticleID uint   `json:"-" db:"article_id"`
+	Position  int    `json:"position"`
+	Title     string `json:"title"`
+	Slug      string `json:"slug"`
+}
+
+// SeriesNavigation places an article within its series.
+type SeriesNavigation struct {
+	Title    string         `json:"title"`
+	Slug     string         `json:"slug"`
+	Position int            `json:"position"`
+	Total    int            `json:"total"`
+	Previous *SeriesArticle `json:"previous"`
+	Next     *SeriesArticle `json:"next"`
+}
+
+// Navigation returns where the article with articleID is in the series, or
+// nil if it is not a member.
+func (s *Series) Navigation(articleID uint) *SeriesNavigation {
+	for i, member := range s.Articles {
+		if member.ArticleID != articleID {
+			continue
+		}
+
+		nav := &SeriesNavigation{
+			Title:    s.Title,
+			Slug:     s.Slug,
+			Position: i + 1,
+			Total:    len(s.Articles),
+		}
+		if i > 0 {
+			nav.Previous = s.Articles[i-1]
+		}
+		if i < len(s.Articles)-1 {
+			nav.Next = s.Articles[i+1]
+		}
+		return nav
+	}
+
+	return nil
+}
+
+type SeriesFilter struct {
+	ID             *uint
+	Slug           *string
+	AuthorUsername *string
+	// ArticleID matches the series the article belongs to.
+	ArticleID *uint
+
+	Limit  int
+	Offset int
+}
+
+type SeriesPatch struct {
+	Title       *string
+	Description *string
+	// ArticleIDs replaces the members of the series, in order.
+	ArticleIDs *[]uint
+}
+
+type SeriesService interface {
+	// CreateSeries creates the series with the articles in articleIDs, in
+	// order. It returns ErrDuplicateSlug if the slug is taken and
+	// ErrArticleInSeries if an article already belongs to another series.
+	CreateSeries(ctx context.Context, series *Series, articleIDs []uint) error
+
+	SeriesBySlug(ctx context.Context, slug string) (*Series, error)
+
+	Series(context.Context, SeriesFilter) ([]*Series, error)
+
+	UpdateSeries(context.Context, *Series, SeriesPatch) error
+
+	DeleteSeries(ctx context.Context, id uint) error
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+const (
+	ScopePasswordReset = "password-reset"
+	ScopeVerification  = "verification"
+)
+
+// Token is a single-use secret handed to a user out of band, e.g. by email.
+// Only the hash of the plaintext is persisted.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    uint      `json:"-" db:"user_id"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+func GenerateToken(userID uint, ttl time.Duration, scope string) (*Token, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	plaintext := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	token := &Token{
+		Plaintext: plaintext,
+		Hash:      HashToken(plaintext),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+
+	return token, nil
+}
+
+func HashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+type TokenService interface {
+	CreateToken(context.Context, *Token) error
+
+	// ConsumeToken deletes the unexpired token matching scope and plaintext and
+	// returns its owner. It returns ErrNotFound if there is no such token.
+	ConsumeToken(ctx context.Context, scope, plaintext string) (*User, error)
+
+	// TokenUser returns the owner of the unexpired token matching scope and
+	// plaintext without consuming it. It returns ErrNotFound if there is no
+	// such token.
+	TokenUser(ctx context.Context, scope, plaintext string) (*User, error)
+
+	DeleteTokensForUser(ctx context.Context, scope string, userID uint) error
+
+	// ResetPassword consumes the password reset token plaintext, gives its
+	// owner passwordHash and signs them out of every session in one
+	// transaction. It returns ErrNotFound if there is no such token.
+	ResetPassword(ctx context.Context, plaintext, passwordHash string) (*User, error)
+}
+
+// GenerateRecoveryCodes returns n random two-factor recovery codes in the
+// form xxxxx-xxxxx. They are stored hashed with HashToken like other tokens.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes = append(codes, s[:5]+"-"+s[5:])
+	}
+	return codes, nil
+}
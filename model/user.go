@@ -2,51 +2,153 @@ package model
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           uint      `json:"-"`
-	Username     string    `json:"username,omitempty"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	Token        string    `json:"token,omitempty"`
-	CreatedAt    time.Time `json:"-" db:"created_at"`
-	UpdatedAt    time.Time `json:"-" db:"updated_at"`
+	ID            uint       `json:"-"`
+	Username      string     `json:"username,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	PasswordHash  string     `json:"-" db:"password_hash"`
+	Token         string     `json:"token,omitempty"`
+	VerifiedAt    *time.Time `json:"-" db:"verified_at"`
+	TOTPSecret    string     `json:"-" db:"totp_secret"`
+	TOTPEnabledAt *time.Time `json:"-" db:"totp_enabled_at"`
+	// TOTPLastStep is the time step of the last TOTP code accepted at login.
+	TOTPLastStep      *int64     `json:"-" db:"totp_last_step"`
+	UsernameChangedAt *time.Time `json:"-" db:"username_changed_at"`
+	Role              Role       `json:"-"`
+	// SessionVersion is embedded in login tokens and bumped to sign the
+	// user out of every session, such as after a password reset.
+	SessionVersion uint      `json:"-" db:"session_version"`
+	CreatedAt      time.Time `json:"-" db:"created_at"`
+	UpdatedAt      time.Time `json:"-" db:"updated_at"`
 }
 
 var AnonymousUser User
 
+// GhostUsername is the placeholder account that takes over articles of
+// deleted users who chose to anonymize them.
+const GhostUsername = "ghost"
+
+// UsernameChangeCooldown is how long users have to wait between renames.
+const UsernameChangeCooldown = 30 * 24 * time.Hour
+
+// reservedUsernames cannot be registered or taken over by renaming because
+// they belong to the service itself or could be mistaken for it.
+var reservedUsernames = map[string]bool{}
+
+func init() {
+	for _, name := range []string{
+		GhostUsername, "admin", "administrator", "root", "system", "api", "www",
+		"mail", "support", "help", "staff", "moderator", "security", "abuse",
+		"me", "user", "users", "profiles", "articles", "series", "settings",
+		"auth", "login", "logout", "signup", "null", "undefined",
+	} {
+		reservedUsernames[name] = true
+	}
+}
+
+// IsReservedUsername reports whether username is reserved, ignoring case.
+func IsReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+// Role grants privileges to a user. Roles are only assigned by operators with
+// the user set-role command. Admins may trash and restore any article.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleUser, RoleAdmin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, use user or admin", s)
+	}
+}
+
+// AuthoredContentPolicy decides what happens to the articles of a deleted user.
+type AuthoredContentPolicy string
+
+const (
+	DeleteAuthoredContent    AuthoredContentPolicy = "delete"
+	AnonymizeAuthoredContent AuthoredContentPolicy = "anonymize"
+)
+
+// Identity links a user to an account at an external identity provider.
+type Identity struct {
+	ID        uint      `json:"-"`
+	UserID    uint      `json:"-" db:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"-" db:"created_at"`
+}
+
 type UserFilter struct {
 	ID       *uint
 	Username *string
+	Email    *string
+	Role     *Role
 
 	Limit  int
 	Offset int
 }
 
 type UserPatch struct {
-	Username     *string `json:"username"`
-	PasswordHash *string `json:"-" db:"password_hash"`
+	Username     *string    `json:"username"`
+	Email        *string    `json:"email"`
+	PasswordHash *string    `json:"-" db:"password_hash"`
+	VerifiedAt   *time.Time `json:"-" db:"verified_at"`
+	TOTPSecret   *string    `json:"-" db:"totp_secret"`
+	Role         *Role      `json:"-"`
 }
 
 func (u *User) SetPassword(password string) error {
-	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := DefaultPasswordHashing.Hash(password)
 	if err != nil {
-		// return better error message
 		return err
 	}
 
-	u.PasswordHash = string(hashBytes)
+	u.PasswordHash = hash
 
 	return nil
 }
 
 func (u User) VerifyPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+	return DefaultPasswordHashing.Verify(u.PasswordHash, password)
+}
+
+// PasswordNeedsRehash reports whether the stored hash was made with an
+// outdated algorithm or cost and should be replaced on the next login.
+func (u User) PasswordNeedsRehash() bool {
+	return DefaultPasswordHashing.NeedsRehash(u.PasswordHash)
+}
 
-	return err == nil
+func (u *User) IsVerified() bool {
+	return u.VerifiedAt != nil
+}
+
+// HasTwoFactor reports whether the user confirmed their TOTP secret. A secret
+// that was set up but never confirmed is not enforced at login.
+func (u *User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// CanChangeUsername reports whether the rename cooldown has passed at now.
+func (u *User) CanChangeUsername(now time.Time) bool {
+	return u.UsernameChangedAt == nil || now.Sub(*u.UsernameChangedAt) >= UsernameChangeCooldown
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
 
 func (u *User) IsAnonymous() bool {
@@ -60,5 +162,47 @@ type UserService interface {
 
 	UserByUsername(ctx context.Context, username string) (*User, error)
 
+	Users(context.Context, UserFilter) ([]*User, error)
+
+	UserByEmail(ctx context.Context, email string) (*User, error)
+
+	// UserByPreviousUsername returns the user who used to be called username.
+	UserByPreviousUsername(ctx context.Context, username string) (*User, error)
+
+	UserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+
+	// CreateUserWithIdentity creates user and links it to identity in one
+	// transaction.
+	CreateUserWithIdentity(context.Context, *User, *Identity) error
+
 	UpdateUser(context.Context, *User, UserPatch) error
+
+	// SetPassword gives the user passwordHash, signs them out of every
+	// session and deletes their password reset tokens in one transaction.
+	SetPassword(ctx context.Context, user *User, passwordHash string) error
+
+	DeleteUser(ctx context.Context, id uint, policy AuthoredContentPolicy) error
+
+	// EnableTwoFactor turns on two-factor authentication for the user and
+	// replaces their recovery codes with the given hashes.
+	EnableTwoFactor(ctx context.Context, userID uint, recoveryCodeHashes [][]byte) error
+
+	// UseRecoveryCode marks the unused recovery code matching hash as used.
+	// It returns ErrNotFound if there is no such code.
+	UseRecoveryCode(ctx context.Context, userID uint, hash []byte) error
+
+	// UseTOTPStep records step as the last time step a TOTP code of the
+	// user was accepted for. It returns ErrNotFound if a code of the same or
+	// a later step was accepted before, which means the code is replayed.
+	UseTOTPStep(ctx context.Context, userID uint, step int64) error
+
+	// ExportUser returns the user with every live and trashed article they
+	// are an author of, read from a single snapshot.
+	ExportUser(ctx context.Context, userID uint) (*UserExport, error)
+}
+
+// UserExport is everything a user can download about themselves.
+type UserExport struct {
+	User     *User
+	Articles []*Article
 }
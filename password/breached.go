@@ -0,0 +1,84 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RangeLookup returns the suffixes of breached SHA-1 password hashes that
+// start with a five character hex prefix. Only the prefix of a hash is ever
+// handed to a lookup, so an implementation backed by a remote k-anonymity API
+// never learns the password or its full hash.
+type RangeLookup interface {
+	Range(prefix string) ([]string, error)
+}
+
+// HashList is a RangeLookup over breached password hashes held in memory.
+type HashList struct {
+	ranges map[string][]string
+}
+
+// LoadHashList reads hashes in the format of the Pwned Passwords downloads:
+// one upper or lower case hex SHA-1 hash per line, optionally followed by
+// ":<count>".
+func LoadHashList(r io.Reader) (*HashList, error) {
+	list := &HashList{ranges: map[string][]string{}}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		hash := strings.ToUpper(strings.SplitN(text, ":", 2)[0])
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: invalid SHA-1 hash", line)
+		}
+
+		list.ranges[hash[:5]] = append(list.ranges[hash[:5]], hash[5:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// LoadHashListFile is LoadHashList for the file at path.
+func LoadHashListFile(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadHashList(f)
+}
+
+func (l *HashList) Range(prefix string) ([]string, error) {
+	return l.ranges[strings.ToUpper(prefix)], nil
+}
+
+// Breached reports whether password appears in lookup.
+func Breached(lookup RangeLookup, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := lookup.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+
+	for _, suffix := range suffixes {
+		if strings.EqualFold(suffix, hash[5:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package password
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+	}{
+		{"password", 0, 0},
+		{"P@ssw0rd", 0, 0},
+		{"aaaaaaaaaaaa", 0, 0},
+		{"qwertyuiop", 1, 0},
+		{"abcdefgh12345678", 2, 0},
+		{"password123", 2, 0},
+		{"correct horse battery staple", 4, 4},
+		{"vT9#kq2!Lm", 4, 4},
+	}
+
+	for _, tt := range tests {
+		got := Score(tt.password)
+		if got < tt.minScore || got > tt.maxScore {
+			t.Errorf("Score(%q) = %d, expected between %d and %d", tt.password, got, tt.minScore, tt.maxScore)
+		}
+	}
+}
+
+func TestPolicy_Violations(t *testing.T) {
+	// SHA-1 of "correct horse battery staple"
+	list, err := LoadHashList(strings.NewReader("ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:42\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := Policy{MinScore: 3, Breached: list}
+
+	tests := []struct {
+		password string
+		username string
+		want     []string
+	}{
+		{"vT9#kq2!Lm", "jane", nil},
+		{"vT9#kq2!Lm-Jane", "jane", []string{"must not contain your username"}},
+		{"correct horse battery staple", "jane", []string{"has appeared in a data breach, choose a different password"}},
+		{"qwerty12", "jane", []string{"is too easy to guess, use a longer password or a few unrelated words"}},
+	}
+
+	for _, tt := range tests {
+		got, err := policy.Violations(tt.password, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Violations(%q, %q) = %v, expected %v", tt.password, tt.username, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package password decides whether a password is good enough to be set: it
+// must be hard to guess, must not contain the username and must not appear in
+// a list of breached passwords.
+package password
+
+import (
+	"strings"
+)
+
+// Policy describes the passwords users may choose.
+type Policy struct {
+	// MinScore is the lowest accepted Score.
+	MinScore int
+	// Breached is consulted to refuse passwords known from data breaches.
+	// No breach check is done when it is nil.
+	Breached RangeLookup
+}
+
+// DefaultPolicy refuses passwords that are guessable with fewer than 10^8
+// guesses.
+var DefaultPolicy = Policy{MinScore: 3}
+
+// minUsernameLength is the shortest username that is looked for in passwords;
+// shorter ones would refuse too many unrelated passwords.
+const minUsernameLength = 3
+
+// Violations lists the reasons password is refused for the user with
+// username. It is empty if password is acceptable.
+func (p *Policy) Violations(password, username string) ([]string, error) {
+	var violations []string
+
+	if len(username) >= minUsernameLength && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "must not contain your username")
+	}
+
+	if Score(password) < p.MinScore {
+		violations = append(violations, "is too easy to guess, use a longer password or a few unrelated words")
+	}
+
+	if p.Breached != nil {
+		breached, err := Breached(p.Breached, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, "has appeared in a data breach, choose a different password")
+		}
+	}
+
+	return violations, nil
+}
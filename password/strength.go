@@ -0,0 +1,227 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Score estimates how hard password is to guess, from 0 (trivial) to 4
+// (very strong), following the approach of zxcvbn: the password is split into
+// the cheapest sequence of guessable patterns — common passwords, repeats,
+// sequences, keyboard walks and brute-forced characters — and the estimated
+// number of guesses is mapped onto the score.
+func Score(password string) int {
+	guesses := Guesses(password)
+
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Guesses returns the estimated number of guesses an attacker needs to find
+// password.
+func Guesses(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 1
+	}
+
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	perChar := float64(cardinality(runes))
+
+	// best[i] is the minimum number of guesses for the first i runes.
+	best := make([]float64, len(runes)+1)
+	best[0] = 1
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] * perChar
+
+		for j := 0; j < i; j++ {
+			if g := patternGuesses(runes[j:i], lower[j:i]); g > 0 {
+				best[i] = math.Min(best[i], best[j]*g)
+			}
+		}
+	}
+
+	return best[len(runes)]
+}
+
+// patternGuesses returns the guesses needed for token if it forms a known
+// pattern, or 0 if it does not.
+func patternGuesses(token, lower []rune) float64 {
+	n := len(token)
+
+	if rank, ok := commonPasswords[string(lower)]; ok {
+		return dictionaryGuesses(rank, token, false)
+	}
+
+	if rank, ok := commonPasswords[unleet(string(lower))]; ok {
+		return dictionaryGuesses(rank, token, true)
+	}
+
+	if n < 3 {
+		return 0
+	}
+
+	if isRepeat(lower) {
+		return float64(cardinality(token[:1]) * n)
+	}
+
+	if isSequence(lower) {
+		return float64(cardinality(token[:1]) * n)
+	}
+
+	if n >= 4 && isKeyboardWalk(lower) {
+		return float64(keyboardStarts * n)
+	}
+
+	return 0
+}
+
+func dictionaryGuesses(rank int, token []rune, leeted bool) float64 {
+	guesses := float64(rank)
+	if leeted {
+		guesses *= 4
+	}
+	if hasUpper(token) {
+		guesses *= 2
+	}
+	return guesses
+}
+
+func cardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	return n
+}
+
+func hasUpper(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+var leet = strings.NewReplacer("4", "a", "@", "a", "8", "b", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// unleet undoes common l33t substitutions. It keeps the length of s so that
+// positions line up with the original password.
+func unleet(s string) string {
+	return leet.Replace(s)
+}
+
+func isRepeat(runes []rune) bool {
+	for _, r := range runes[1:] {
+		if r != runes[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isSequence(runes []rune) bool {
+	delta := runes[1] - runes[0]
+	if delta != 1 && delta != -1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != delta {
+			return false
+		}
+	}
+	return true
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+// keyboardStarts approximates the number of keys a keyboard walk can start on.
+const keyboardStarts = 47
+
+func isKeyboardWalk(runes []rune) bool {
+	s := string(runes)
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// commonPasswords ranks frequently used passwords and words by popularity.
+var commonPasswords = func() map[string]int {
+	words := []string{
+		"password", "123456", "12345678", "qwerty", "abc123", "monkey", "letmein",
+		"dragon", "111111", "baseball", "iloveyou", "trustno1", "sunshine",
+		"master", "welcome", "shadow", "ashley", "football", "jesus", "michael",
+		"ninja", "mustang", "password1", "admin", "login", "princess", "starwars",
+		"superman", "batman", "hello", "freedom", "whatever", "qazwsx", "charlie",
+		"donald", "secret", "summer", "winter", "spring", "autumn", "computer",
+		"internet", "server", "blog", "techblog", "access", "flower", "hunter",
+		"soccer", "hockey", "killer", "george", "pepper", "jordan", "harley",
+		"ranger", "buster", "thomas", "robert", "daniel", "andrew", "love",
+		"god", "test", "pass", "user", "root", "guest", "changeme", "default",
+	}
+
+	ranks := make(map[string]int, len(words))
+	for i, w := range words {
+		ranks[w] = i + 1
+	}
+	return ranks
+}()
@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var _ model.PersonalAccessTokenService = (*PersonalAccessTokenService)(nil)
+
+type PersonalAccessTokenService struct {
+	db *DB
+}
+
+func NewPersonalAccessTokenService(db *DB) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{db}
+}
+
+// personalAccessTokenRow maps the scopes array, which sqlx cannot scan into a
+// plain string slice.
+type personalAccessTokenRow struct {
+	ID         uint
+	UserID     uint `db:"user_id"`
+	Name       string
+	TokenHash  []byte         `db:"token_hash"`
+	Scopes     pq.StringArray `db:"scopes"`
+	ExpiresAt  *time.Time     `db:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	RevokedAt  *time.Time     `db:"revoked_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+func (row *personalAccessTokenRow) model() *model.PersonalAccessToken {
+	return &model.PersonalAccessToken{
+		ID:         row.ID,
+		UserID:     row.UserID,
+		Name:       row.Name,
+		Hash:       row.TokenHash,
+		Scopes:     []string(row.Scopes),
+		ExpiresAt:  row.ExpiresAt,
+		LastUsedAt: row.LastUsedAt,
+		RevokedAt:  row.RevokedAt,
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+func (ps *PersonalAccessTokenService) CreatePersonalAccessToken(ctx context.Context, token *model.PersonalAccessToken) error {
+	ctx, span := startSpan(ctx, "PersonalAccessTokenService.CreatePersonalAccessToken")
+	defer span.End()
+
+	tx, err := ps.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := createPersonalAccessToken(ctx, tx, token); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createPersonalAccessToken(ctx context.Context, tx *sqlx.Tx, token *model.PersonalAccessToken) error {
+	query := `
+	INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+
+	args := []interface{}{
+		token.UserID,
+		token.Name,
+		token.Hash,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	}
+
+	return tx.QueryRowxContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (ps *PersonalAccessTokenService) PersonalAccessTokens(ctx context.Context, filter model.PersonalAccessTokenFilter) ([]*model.PersonalAccessToken, error) {
+	ctx, span := startSpan(ctx, "PersonalAccessTokenService.PersonalAccessTokens")
+	defer span.End()
+
+	tx, err := ps.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := findPersonalAccessTokens(ctx, tx, filter)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+func findPersonalAccessTokens(ctx context.Context, tx *sqlx.Tx, filter model.PersonalAccessTokenFilter) ([]*model.PersonalAccessToken, error) {
+	where, args := []string{}, []interface{}{}
+	argPosition := 0
+
+	if v := filter.ID; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("id = $%d", argPosition)), append(args, *v)
+	}
+
+	if v := filter.UserID; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("user_id = $%d", argPosition)), append(args, *v)
+	}
+
+	query := "SELECT * FROM personal_access_tokens" + formatWhereClause(where) + " ORDER BY id ASC"
+
+	rows := make([]*personalAccessTokenRow, 0)
+	if err := findMany(ctx, tx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*model.PersonalAccessToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, row.model())
+	}
+
+	return tokens, nil
+}
+
+func (ps *PersonalAccessTokenService) AuthenticatePersonalAccessToken(ctx context.Context, plaintext string) (*model.PersonalAccessToken, *model.User, error) {
+	ctx, span := startSpan(ctx, "PersonalAccessTokenService.AuthenticatePersonalAccessToken")
+	defer span.End()
+
+	tx, err := ps.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, user, err := authenticatePersonalAccessToken(ctx, tx, plaintext)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, nil, rollbackErr
+		}
+		return nil, nil, err
+	}
+
+	return token, user, tx.Commit()
+}
+
+func authenticatePersonalAccessToken(ctx context.Context, tx *sqlx.Tx, plaintext string) (*model.PersonalAccessToken, *model.User, error) {
+	query := `
+	UPDATE personal_access_tokens SET last_used_at = NOW()
+	WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	RETURNING *`
+
+	row := personalAccessTokenRow{}
+	if err := tx.QueryRowxContext(ctx, query, model.HashToken(plaintext)).StructScan(&row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, model.ErrUnAuthorized
+		}
+		return nil, nil, err
+	}
+
+	user, err := findUserByID(ctx, tx, row.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return row.model(), user, nil
+}
+
+func (ps *PersonalAccessTokenService) RevokePersonalAccessToken(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "PersonalAccessTokenService.RevokePersonalAccessToken")
+	defer span.End()
+
+	tx, err := ps.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := revokePersonalAccessToken(ctx, tx, id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func revokePersonalAccessToken(ctx context.Context, tx *sqlx.Tx, id uint) error {
+	query := "UPDATE personal_access_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL"
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
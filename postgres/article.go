@@ -2,24 +2,32 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/msksgm/go-techblog-msksgm/model"
 )
 
 var _ model.ArticleService = (*ArticleService)(nil)
 
 type ArticleService struct {
-	db *DB
+	db      *DB
+	related *relatedCache
 }
 
 func NewArticleService(db *DB) *ArticleService {
-	return &ArticleService{db}
+	return &ArticleService{db: db, related: db.related}
 }
 
 func (as *ArticleService) CreateArticle(ctx context.Context, article *model.Article) error {
+	ctx, span := startSpan(ctx, "ArticleService.CreateArticle")
+	defer span.End()
+
 	tx, err := as.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
@@ -32,7 +40,13 @@ func (as *ArticleService) CreateArticle(ctx context.Context, article *model.Arti
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.clear()
+
+	return nil
 }
 
 func createArticle(ctx context.Context, tx *sqlx.Tx, article *model.Article) error {
@@ -50,13 +64,24 @@ func createArticle(ctx context.Context, tx *sqlx.Tx, article *model.Article) err
 
 	err := tx.QueryRowxContext(ctx, query, args...).Scan(&article.ID, &article.CreatedAt, &article.UpdatedAt)
 	if err != nil {
+		return articleConstraintError(err)
+	}
+
+	query = `
+	INSERT INTO article_authors (article_id, user_id, position, invited_at, accepted_at)
+	VALUES ($1, $2, 0, $3, $3)`
+
+	if err := execQuery(ctx, tx, query, article.ID, article.AuthorID, article.CreatedAt); err != nil {
 		return err
 	}
 
-	return nil
+	return attachArticleAuthors(ctx, tx, article)
 }
 
 func (as *ArticleService) Articles(ctx context.Context, filter model.ArticleFilter) ([]*model.Article, error) {
+	ctx, span := startSpan(ctx, "ArticleService.Articles")
+	defer span.End()
+
 	tx, err := as.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -99,11 +124,25 @@ func findArticles(ctx context.Context, tx *sqlx.Tx, filter model.ArticleFilter)
 
 	if v := filter.AuthorUsername; v != nil {
 		argPosition++
-		clause := "author_id = (select id from users where username = $%d)"
+		clause := `id IN (
+			SELECT aa.article_id FROM article_authors aa JOIN users u ON u.id = aa.user_id
+			WHERE u.username = $%d AND aa.accepted_at IS NOT NULL)`
+		where, args = append(where, fmt.Sprintf(clause, argPosition)), append(args, *v)
+	}
+
+	if v := filter.InvitedUserID; v != nil {
+		argPosition++
+		clause := "id IN (SELECT article_id FROM article_authors WHERE user_id = $%d AND accepted_at IS NULL)"
 		where, args = append(where, fmt.Sprintf(clause, argPosition)), append(args, *v)
 	}
 
-	query := "SELECT * from articles" + formatWhereClause(where) + " ORDER BY created_at DESC"
+	if filter.Trashed {
+		where = append(where, "deleted_at IS NOT NULL")
+	} else {
+		where = append(where, "deleted_at IS NULL")
+	}
+
+	query := "SELECT * from articles" + formatWhereClause(where) + " ORDER BY created_at DESC" + formatLimitOffset(filter.Limit, filter.Offset)
 	articles, err := queryArticles(ctx, tx, query, args...)
 	if err != nil {
 		return articles, err
@@ -125,6 +164,10 @@ func queryArticles(ctx context.Context, tx *sqlx.Tx, query string, args ...inter
 		}
 	}
 
+	if err := attachArticleAuthors(ctx, tx, articles...); err != nil {
+		return nil, err
+	}
+
 	return articles, nil
 }
 
@@ -139,7 +182,48 @@ func attachArticleAssociation(ctx context.Context, tx *sqlx.Tx, article *model.A
 	return nil
 }
 
+// attachArticleAuthors loads the accepted authors of all articles in one
+// query.
+func attachArticleAuthors(ctx context.Context, tx *sqlx.Tx, articles ...*model.Article) error {
+	if len(articles) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(articles))
+	for i, article := range articles {
+		ids[i] = article.ID
+	}
+
+	query := `
+	SELECT aa.article_id, aa.user_id, u.username, aa.position, aa.invited_at, aa.accepted_at
+	FROM article_authors aa JOIN users u ON u.id = aa.user_id
+	WHERE aa.article_id = ANY($1) AND aa.accepted_at IS NOT NULL
+	ORDER BY aa.position ASC`
+
+	authors := make([]*model.ArticleAuthor, 0)
+	if err := findMany(ctx, tx, &authors, query, pq.Array(ids)); err != nil {
+		return fmt.Errorf("cannot find article authors: %w", err)
+	}
+
+	byArticle := make(map[uint][]*model.ArticleAuthor, len(articles))
+	for _, author := range authors {
+		byArticle[author.ArticleID] = append(byArticle[author.ArticleID], author)
+	}
+
+	for _, article := range articles {
+		article.Authors = byArticle[article.ID]
+		if article.Authors == nil {
+			article.Authors = make([]*model.ArticleAuthor, 0)
+		}
+	}
+
+	return nil
+}
+
 func (as *ArticleService) ArticleBySlug(ctx context.Context, slug string) (*model.Article, error) {
+	ctx, span := startSpan(ctx, "ArticleService.ArticleBySlug")
+	defer span.End()
+
 	tx, err := as.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -171,6 +255,9 @@ func findArticleBySlug(ctx context.Context, tx *sqlx.Tx, slug string) (*model.Ar
 }
 
 func (as *ArticleService) UpdateArticle(ctx context.Context, article *model.Article, filter model.ArticlePatch) error {
+	ctx, span := startSpan(ctx, "ArticleService.UpdateArticle")
+	defer span.End()
+
 	tx, err := as.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
@@ -184,7 +271,13 @@ func (as *ArticleService) UpdateArticle(ctx context.Context, article *model.Arti
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.invalidate(article.ID)
+
+	return nil
 }
 
 func updateArticle(ctx context.Context, tx *sqlx.Tx, article *model.Article, patch model.ArticlePatch) error {
@@ -200,28 +293,36 @@ func updateArticle(ctx context.Context, tx *sqlx.Tx, article *model.Article, pat
 		article.Body,
 		article.Title,
 		article.ID,
+		article.Version,
 	}
 
 	query := `
 	UPDATE articles
-	SET body = $1, title = $2, updated_at = NOW() WHERE id = $3
-	RETURNING updated_at`
+	SET body = $1, title = $2, version = version + 1, updated_at = NOW()
+	WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+	RETURNING version, updated_at`
 
-	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&article.UpdatedAt); err != nil {
-		log.Printf("error updating record: %v", err)
+	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&article.Version, &article.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrEditConflict
+		}
+		slog.ErrorContext(ctx, "error updating record", "error", err)
 		return model.ErrInternal
 	}
 
 	return nil
 }
 
-func (as *ArticleService) DeleteArticle(ctx context.Context, id uint) error {
+func (as *ArticleService) DeleteArticle(ctx context.Context, article *model.Article) error {
+	ctx, span := startSpan(ctx, "ArticleService.DeleteArticle")
+	defer span.End()
+
 	tx, err := as.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	err = deleteArticle(ctx, tx, id)
+	err = deleteArticle(ctx, tx, article)
 	if err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return rollbackErr
@@ -229,11 +330,323 @@ func (as *ArticleService) DeleteArticle(ctx context.Context, id uint) error {
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.invalidate(article.ID)
+
+	return nil
+}
+
+// deleteArticle trashes the article only if it is still at article.Version,
+// so that a delete guarded by If-Match cannot race with an update.
+func deleteArticle(ctx context.Context, tx *sqlx.Tx, article *model.Article) error {
+	query := `
+	UPDATE articles SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	RETURNING version, deleted_at`
+
+	if err := tx.QueryRowxContext(ctx, query, article.ID, article.Version).Scan(&article.Version, &article.DeletedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrEditConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (as *ArticleService) RestoreArticle(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "ArticleService.RestoreArticle")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = restoreArticle(ctx, tx, id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.clear()
+
+	return nil
+}
+
+func restoreArticle(ctx context.Context, tx *sqlx.Tx, id uint) error {
+	query := "UPDATE articles SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL"
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return articleConstraintError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+// articleConstraintError maps a slug taken by a live article to
+// ErrDuplicateSlug. Trashed articles do not hold on to their slugs.
+func articleConstraintError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "articles_live_slug_key"`:
+		return model.ErrDuplicateSlug
+	default:
+		return err
+	}
+}
+
+func (as *ArticleService) PurgeArticles(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, span := startSpan(ctx, "ArticleService.PurgeArticles")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := purgeArticles(ctx, tx, deletedBefore)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		as.related.clear()
+	}
+
+	return n, nil
+}
+
+func purgeArticles(ctx context.Context, tx *sqlx.Tx, deletedBefore time.Time) (int64, error) {
+	query := "DELETE FROM articles WHERE deleted_at IS NOT NULL AND deleted_at < $1"
+
+	result, err := tx.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (as *ArticleService) InviteCoAuthor(ctx context.Context, articleID, userID uint) error {
+	ctx, span := startSpan(ctx, "ArticleService.InviteCoAuthor")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := inviteCoAuthor(ctx, tx, articleID, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func deleteArticle(ctx context.Context, tx *sqlx.Tx, id uint) error {
-	query := "DELETE FROM articles WHERE id = $1"
+func inviteCoAuthor(ctx context.Context, tx *sqlx.Tx, articleID, userID uint) error {
+	// Concurrent invitations would otherwise read the same MAX(position).
+	var id uint
+	query := "SELECT id FROM articles WHERE id = $1 FOR UPDATE"
+	if err := tx.QueryRowxContext(ctx, query, articleID).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrNotFound
+		}
+		return err
+	}
+
+	query = `
+	INSERT INTO article_authors (article_id, user_id, position)
+	SELECT $1, $2, COALESCE(MAX(position), 0) + 1 FROM article_authors WHERE article_id = $1
+	ON CONFLICT (article_id, user_id) DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, articleID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrDuplicateAuthor
+	}
+
+	return nil
+}
+
+func (as *ArticleService) AcceptCoAuthorInvitation(ctx context.Context, articleID, userID uint) error {
+	ctx, span := startSpan(ctx, "ArticleService.AcceptCoAuthorInvitation")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := acceptCoAuthorInvitation(ctx, tx, articleID, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.invalidate(articleID)
+
+	return nil
+}
+
+func acceptCoAuthorInvitation(ctx context.Context, tx *sqlx.Tx, articleID, userID uint) error {
+	query := `
+	UPDATE article_authors SET accepted_at = NOW()
+	WHERE article_id = $1 AND user_id = $2 AND accepted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, articleID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+func (as *ArticleService) RemoveCoAuthor(ctx context.Context, articleID, userID uint) error {
+	ctx, span := startSpan(ctx, "ArticleService.RemoveCoAuthor")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := removeCoAuthor(ctx, tx, articleID, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.invalidate(articleID)
+
+	return nil
+}
+
+func removeCoAuthor(ctx context.Context, tx *sqlx.Tx, articleID, userID uint) error {
+	query := "DELETE FROM article_authors WHERE article_id = $1 AND user_id = $2 AND position > 0"
+
+	result, err := tx.ExecContext(ctx, query, articleID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+func (as *ArticleService) ReassignArticle(ctx context.Context, articleID, userID uint) error {
+	ctx, span := startSpan(ctx, "ArticleService.ReassignArticle")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := reassignArticle(ctx, tx, articleID, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	as.related.invalidate(articleID)
+
+	return nil
+}
+
+func reassignArticle(ctx context.Context, tx *sqlx.Tx, articleID, userID uint) error {
+	query := "UPDATE articles SET author_id = $1, version = version + 1, updated_at = NOW() WHERE id = $2"
+
+	result, err := tx.ExecContext(ctx, query, userID, articleID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	query = "DELETE FROM article_authors WHERE article_id = $1 AND user_id = $2 AND position > 0"
+	if err := execQuery(ctx, tx, query, articleID, userID); err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE article_authors SET user_id = $1, accepted_at = COALESCE(accepted_at, NOW())
+	WHERE article_id = $2 AND position = 0`
 
-	return execQuery(ctx, tx, query, id)
+	return execQuery(ctx, tx, query, userID, articleID)
 }
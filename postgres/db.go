@@ -1,26 +1,85 @@
 package postgres
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DB struct {
 	*sqlx.DB
+
+	// related caches recommendations for all services sharing the
+	// connection, since both article and series writes change them.
+	related *relatedCache
+}
+
+// PoolOptions size the connection pool. Zero values keep the defaults of
+// database/sql.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
-func Open(url string) (*DB, error) {
-	db, err := sqlx.Open("postgres", url)
+// Open connects to the database at url. Every statement is traced with the
+// global tracer provider.
+func Open(url string, pool PoolOptions) (*DB, error) {
+	sqlDB, err := otelsql.Open("postgres", url,
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
+
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
 
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
-	log.Println("successfully connected to database")
-	return &DB{db}, nil
+	slog.Info("successfully connected to database")
+	return &DB{DB: db, related: newRelatedCache()}, nil
+}
+
+// MigrationVersion returns the schema version recorded by golang-migrate and
+// whether the last migration failed halfway. The version is 0 if no
+// migration has run yet.
+func (db *DB) MigrationVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	query := "SELECT version, dirty FROM schema_migrations LIMIT 1"
+
+	err = db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+var tracer = otel.Tracer("github.com/msksgm/go-techblog-msksgm/postgres")
+
+// startSpan starts the span of a service method. The statements it runs are
+// traced as children of the span.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
 }
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var _ model.LoginAttemptService = (*LoginAttemptService)(nil)
+
+type LoginAttemptService struct {
+	db *DB
+}
+
+func NewLoginAttemptService(db *DB) *LoginAttemptService {
+	return &LoginAttemptService{db}
+}
+
+func (ls *LoginAttemptService) RecordLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error {
+	ctx, span := startSpan(ctx, "LoginAttemptService.RecordLoginAttempt")
+	defer span.End()
+
+	tx, err := ls.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := recordLoginAttempt(ctx, tx, attempt); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func recordLoginAttempt(ctx context.Context, tx *sqlx.Tx, attempt *model.LoginAttempt) error {
+	query := `
+	INSERT INTO login_attempts (username, ip, success)
+	VALUES ($1, $2, $3) RETURNING id, created_at`
+
+	args := []interface{}{attempt.Username, attempt.IP, attempt.Success}
+
+	return tx.QueryRowxContext(ctx, query, args...).Scan(&attempt.ID, &attempt.CreatedAt)
+}
+
+func (ls *LoginAttemptService) SucceedLoginAttempt(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "LoginAttemptService.SucceedLoginAttempt")
+	defer span.End()
+
+	tx, err := ls.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := execQuery(ctx, tx, "UPDATE login_attempts SET success = TRUE WHERE id = $1", id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ls *LoginAttemptService) DeleteLoginAttempt(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "LoginAttemptService.DeleteLoginAttempt")
+	defer span.End()
+
+	tx, err := ls.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := execQuery(ctx, tx, "DELETE FROM login_attempts WHERE id = $1", id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ls *LoginAttemptService) LoginFailures(ctx context.Context, filter model.LoginAttemptFilter) (model.LoginFailures, error) {
+	ctx, span := startSpan(ctx, "LoginAttemptService.LoginFailures")
+	defer span.End()
+
+	tx, err := ls.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return model.LoginFailures{}, err
+	}
+
+	failures, err := findLoginFailures(ctx, tx, filter)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return model.LoginFailures{}, rollbackErr
+		}
+		return model.LoginFailures{}, err
+	}
+
+	return failures, tx.Commit()
+}
+
+func findLoginFailures(ctx context.Context, tx *sqlx.Tx, filter model.LoginAttemptFilter) (model.LoginFailures, error) {
+	where, args := []string{"NOT success", "created_at > $1"}, []interface{}{filter.Since}
+	argPosition := 1
+
+	if v := filter.Username; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("username = $%d", argPosition)), append(args, *v)
+
+		if filter.SinceLastSuccess {
+			clause := "created_at > COALESCE((SELECT MAX(created_at) FROM login_attempts WHERE username = $%d AND success), '-infinity')"
+			where = append(where, fmt.Sprintf(clause, argPosition))
+		}
+	}
+
+	if v := filter.IP; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("ip = $%d", argPosition)), append(args, *v)
+	}
+
+	if v := filter.ExceptID; v != 0 {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("id <> $%d", argPosition)), append(args, v)
+	}
+
+	query := "SELECT COUNT(*), MAX(created_at) FROM login_attempts" + formatWhereClause(where)
+
+	var (
+		failures model.LoginFailures
+		last     sql.NullTime
+	)
+	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&failures.Count, &last); err != nil {
+		return model.LoginFailures{}, err
+	}
+	failures.Last = last.Time
+
+	return failures, nil
+}
+
+func (ls *LoginAttemptService) PurgeLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := startSpan(ctx, "LoginAttemptService.PurgeLoginAttempts")
+	defer span.End()
+
+	result, err := ls.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
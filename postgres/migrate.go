@@ -0,0 +1,382 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the advisory lock held while migrating, so that
+// replicas starting at the same time do not migrate concurrently.
+const migrationLockKey = 7_316_224_915
+
+var (
+	// ErrDirtySchema means a migration failed halfway. The schema has to be
+	// repaired by hand and the version set with Migrator.Force.
+	ErrDirtySchema = errors.New("database schema is dirty")
+	// ErrSchemaAhead means the database was migrated by a newer binary.
+	ErrSchemaAhead = errors.New("database schema is newer than this binary")
+	// ErrUnknownVersion means there is no migration with the requested version.
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// Migration is one step of the schema, read from the embedded
+// migrations/<version>_<name>.{up,down}.sql files.
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus describes the schema of a database.
+type MigrationStatus struct {
+	// Current is the version of the database, 0 if it was never migrated.
+	Current uint
+	Dirty   bool
+	// Latest is the version of the newest embedded migration.
+	Latest  uint
+	Pending []Migration
+}
+
+// Migrator applies the embedded migrations. It keeps the bookkeeping of the
+// migrate CLI, a schema_migrations table holding the version and a dirty
+// flag, so databases migrated with either tool can be migrated with the other.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+func NewMigrator(db *DB) (*Migrator, error) {
+	migrations, err := readMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+func readMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+
+	for _, entry := range entries {
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: m[2]}
+			byVersion[uint(version)] = migration
+		}
+
+		if m[3] == "up" {
+			migration.up = string(b)
+		} else {
+			migration.down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d must have an up and a down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	// A gap usually means a file was renamed or lost, and migrating across
+	// it would silently skip a step.
+	for i, migration := range migrations {
+		if migration.Version != uint(i+1) {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Status(ctx context.Context) (*MigrationStatus, error) {
+	var status *MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := migrationVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		status = &MigrationStatus{Current: current, Dirty: dirty, Latest: m.Latest()}
+		for _, migration := range m.migrations {
+			if migration.Version > current {
+				status.Pending = append(status.Pending, migration)
+			}
+		}
+		return nil
+	})
+
+	return status, err
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the latest applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := migrationVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirtySchema, current)
+		}
+		if current == 0 {
+			return nil
+		}
+
+		i := m.index(current)
+		if i < 0 {
+			return fmt.Errorf("%w: %d", ErrSchemaAhead, current)
+		}
+
+		var previous uint
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+
+		return m.migrate(ctx, conn, current, previous)
+	})
+}
+
+// To migrates up or down to version. Version 0 reverts all migrations.
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := migrationVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirtySchema, current)
+		}
+		if current != 0 && m.index(current) < 0 {
+			return fmt.Errorf("%w: %d", ErrSchemaAhead, current)
+		}
+
+		return m.migrate(ctx, conn, current, version)
+	})
+}
+
+// Force records version as the current, clean version without running any
+// migration. It is meant for recovering after a migration failed halfway and
+// the schema was repaired by hand.
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return setMigrationVersion(ctx, conn, version, false)
+	})
+}
+
+// CheckSchema returns an error if the database cannot be used by this binary
+// because a migration failed halfway or it was migrated by a newer binary.
+func (m *Migrator) CheckSchema(ctx context.Context) (*MigrationStatus, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, checkMigrationStatus(status)
+}
+
+func checkMigrationStatus(status *MigrationStatus) error {
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("%w at version %d", ErrDirtySchema, status.Current)
+	case status.Current > status.Latest:
+		return fmt.Errorf("%w: database is at version %d, this binary knows up to %d", ErrSchemaAhead, status.Current, status.Latest)
+	}
+
+	return nil
+}
+
+// migrate runs the migrations between from and to, one at a time. Like the
+// migrate CLI, each step is marked dirty until its file has run, since the
+// files manage their own transactions.
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, from, to uint) error {
+	for _, step := range m.plan(from, to) {
+		if err := setMigrationVersion(ctx, conn, step.version, true); err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "applying migration", "version", step.migration.Version, "name", step.migration.Name, "direction", step.direction)
+
+		if _, err := conn.ExecContext(ctx, step.query); err != nil {
+			return fmt.Errorf("migration %d_%s %s: %w", step.migration.Version, step.migration.Name, step.direction, err)
+		}
+
+		if err := setMigrationVersion(ctx, conn, step.version, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type migrationStep struct {
+	migration Migration
+	direction string
+	query     string
+	// version is the version of the schema after the step.
+	version uint
+}
+
+func (m *Migrator) plan(from, to uint) []migrationStep {
+	var steps []migrationStep
+
+	if to >= from {
+		for _, migration := range m.migrations {
+			if migration.Version > from && migration.Version <= to {
+				steps = append(steps, migrationStep{migration, "up", migration.up, migration.Version})
+			}
+		}
+		return steps
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > from || migration.Version <= to {
+			continue
+		}
+
+		var previous uint
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+		steps = append(steps, migrationStep{migration, "down", migration.down, previous})
+	}
+
+	return steps
+}
+
+func (m *Migrator) index(version uint) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// withLock runs fn on a single connection holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("cannot acquire migration lock: %w", err)
+	}
+	defer func() {
+		// The lock is released with the session if this fails.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			slog.Error("error releasing migration lock", "error", err)
+		}
+	}()
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func migrationVersion(ctx context.Context, conn *sql.Conn) (version uint, dirty bool, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// setMigrationVersion replaces the single row of schema_migrations. Version 0
+// leaves the table empty, as the migrate CLI does.
+func setMigrationVersion(ctx context.Context, conn *sql.Conn, version uint, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if version != 0 || dirty {
+		query := "INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)"
+		if _, err := tx.ExecContext(ctx, query, version, dirty); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
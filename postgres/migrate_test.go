@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func migrationFS(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func Test_readMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []uint
+		wantErr  string
+	}{
+		{
+			name:     "ordered by version",
+			fsys:     migrationFS("000002_b.up.sql", "000002_b.down.sql", "000001_a.up.sql", "000001_a.down.sql"),
+			versions: []uint{1, 2},
+		},
+		{
+			name:    "missing up file",
+			fsys:    migrationFS("000001_a.up.sql", "000001_a.down.sql", "000002_b.down.sql"),
+			wantErr: "migration 2 must have an up and a down file",
+		},
+		{
+			name:    "missing down file",
+			fsys:    migrationFS("000001_a.up.sql"),
+			wantErr: "migration 1 must have an up and a down file",
+		},
+		{
+			name:    "version gap",
+			fsys:    migrationFS("000001_a.up.sql", "000001_a.down.sql", "000003_c.up.sql", "000003_c.down.sql"),
+			wantErr: "migration 2 is missing",
+		},
+		{
+			name:    "version zero",
+			fsys:    migrationFS("000000_a.up.sql", "000000_a.down.sql"),
+			wantErr: "invalid migration version",
+		},
+		{
+			name:    "unexpected file",
+			fsys:    migrationFS("000001_a.up.sql", "000001_a.down.sql", "README.md"),
+			wantErr: "unexpected migration file README.md",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := readMigrations(tt.fsys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, but got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versions := make([]uint, len(migrations))
+			for i, migration := range migrations {
+				versions[i] = migration.Version
+			}
+			if !reflect.DeepEqual(versions, tt.versions) {
+				t.Errorf("expected versions %v, but got %v", tt.versions, versions)
+			}
+		})
+	}
+}
+
+func Test_readMigrations_embedded(t *testing.T) {
+	if _, err := readMigrations(migrationFiles); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Migrator_plan(t *testing.T) {
+	migrations, err := readMigrations(migrationFS(
+		"000001_a.up.sql", "000001_a.down.sql",
+		"000002_b.up.sql", "000002_b.down.sql",
+		"000003_c.up.sql", "000003_c.down.sql",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Migrator{migrations: migrations}
+
+	tests := []struct {
+		name     string
+		from, to uint
+		want     []string
+	}{
+		{"up from scratch", 0, 3, []string{"1 up -> 1", "2 up -> 2", "3 up -> 3"}},
+		{"up part of the way", 1, 2, []string{"2 up -> 2"}},
+		{"down one", 3, 2, []string{"3 down -> 2"}},
+		{"down to 0", 3, 0, []string{"3 down -> 2", "2 down -> 1", "1 down -> 0"}},
+		{"already there", 2, 2, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, step := range m.plan(tt.from, tt.to) {
+				if !strings.HasSuffix(step.query, "_"+step.migration.Name+"."+step.direction+".sql") {
+					t.Errorf("expected the %s query of migration %d, but got %q", step.direction, step.migration.Version, step.query)
+				}
+				got = append(got, fmt.Sprintf("%d %s -> %d", step.migration.Version, step.direction, step.version))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected steps %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_checkMigrationStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  MigrationStatus
+		wantErr error
+	}{
+		{"current", MigrationStatus{Current: 3, Latest: 3}, nil},
+		{"pending", MigrationStatus{Current: 1, Latest: 3}, nil},
+		{"dirty", MigrationStatus{Current: 2, Dirty: true, Latest: 3}, ErrDirtySchema},
+		{"ahead", MigrationStatus{Current: 4, Latest: 3}, ErrSchemaAhead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMigrationStatus(&tt.status)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS articles_deleted_at_idx;
+
+ALTER TABLE articles DROP COLUMN IF EXISTS deleted_at;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM users WHERE username = 'ghost';
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- ghost owns the articles of deleted accounts that chose to anonymize them.
+-- The password hash is not a valid hash so the account can never log in.
+INSERT INTO users (username, password_hash) VALUES ('ghost', '!');
+
+COMMIT;
\ No newline at end of file
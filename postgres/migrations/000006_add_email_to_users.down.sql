@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users DROP COLUMN IF EXISTS email;
+
+COMMIT;
\ No newline at end of file
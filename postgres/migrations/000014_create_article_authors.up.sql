@@ -0,0 +1,20 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS article_authors (
+    article_id INT NOT NULL,
+    user_id INT NOT NULL,
+    position INT NOT NULL,
+    invited_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMPTZ,
+    PRIMARY KEY (article_id, user_id),
+    CONSTRAINT fk_article FOREIGN KEY(article_id) REFERENCES articles(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS article_authors_user_id_idx ON article_authors (user_id);
+
+INSERT INTO article_authors (article_id, user_id, position, invited_at, accepted_at)
+SELECT id, author_id, 0, created_at, created_at FROM articles
+ON CONFLICT DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,25 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS series (
+    id SERIAL PRIMARY KEY,
+    title TEXT NOT NULL,
+    slug TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    author_id INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_author FOREIGN KEY(author_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT series_slug_key UNIQUE (slug)
+);
+
+CREATE TABLE IF NOT EXISTS series_articles (
+    series_id INT NOT NULL,
+    article_id INT NOT NULL,
+    position INT NOT NULL,
+    PRIMARY KEY (series_id, article_id),
+    CONSTRAINT fk_series FOREIGN KEY(series_id) REFERENCES series(id) ON DELETE CASCADE,
+    CONSTRAINT fk_article FOREIGN KEY(article_id) REFERENCES articles(id) ON DELETE CASCADE,
+    CONSTRAINT series_articles_article_id_key UNIQUE (article_id)
+);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS articles_title_trgm_idx;
+
+DROP EXTENSION IF EXISTS pg_trgm;
+
+COMMIT;
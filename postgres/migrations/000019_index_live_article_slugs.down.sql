@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS articles_live_slug_key;
+
+ALTER TABLE articles ADD CONSTRAINT articles_slug_key UNIQUE (slug);
+
+COMMIT;
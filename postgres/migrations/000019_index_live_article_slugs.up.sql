@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE articles DROP CONSTRAINT IF EXISTS articles_slug_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS articles_live_slug_key ON articles (slug) WHERE deleted_at IS NULL;
+
+COMMIT;
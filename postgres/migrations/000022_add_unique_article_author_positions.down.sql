@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE article_authors DROP CONSTRAINT IF EXISTS article_authors_position_key;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Renumber co-authors that were given the same position by concurrent
+-- invitations before the constraint is added.
+UPDATE article_authors aa SET position = ranked.position
+FROM (
+    SELECT article_id, user_id, ROW_NUMBER() OVER (PARTITION BY article_id ORDER BY position, invited_at, user_id) AS position
+    FROM article_authors
+    WHERE position > 0
+) ranked
+WHERE aa.article_id = ranked.article_id AND aa.user_id = ranked.user_id AND aa.position <> ranked.position;
+
+ALTER TABLE article_authors ADD CONSTRAINT article_authors_position_key UNIQUE (article_id, position);
+
+COMMIT;
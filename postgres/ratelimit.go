@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var _ model.RateLimiter = (*RateLimiter)(nil)
+
+// rateLimitSweepInterval is how often a replica deletes buckets that have
+// refilled, which are equivalent to missing ones.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter keeps rate limit buckets in the database so that all replicas
+// share them. Timestamps come from the database clock, so replicas need not
+// agree on the time.
+type RateLimiter struct {
+	db *DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewRateLimiter(db *DB) *RateLimiter {
+	return &RateLimiter{db: db}
+}
+
+func (rl *RateLimiter) Allow(ctx context.Context, key string, limit model.RateLimit) (model.RateLimitResult, error) {
+	ctx, span := startSpan(ctx, "RateLimiter.Allow")
+	defer span.End()
+
+	rl.sweep(ctx)
+
+	tx, err := rl.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return model.RateLimitResult{}, err
+	}
+
+	result, err := takeRateLimitToken(ctx, tx, key, limit)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return model.RateLimitResult{}, rollbackErr
+		}
+		return model.RateLimitResult{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+func takeRateLimitToken(ctx context.Context, tx *sqlx.Tx, key string, limit model.RateLimit) (model.RateLimitResult, error) {
+	// Creating the bucket first gives concurrent requests a row to lock.
+	query := `
+	INSERT INTO rate_limit_buckets (key, tokens, updated_at, expires_at)
+	VALUES ($1, $2, NOW(), NOW())
+	ON CONFLICT (key) DO NOTHING`
+
+	if err := execQuery(ctx, tx, query, key, limit.Limit); err != nil {
+		return model.RateLimitResult{}, err
+	}
+
+	var bucket model.RateLimitBucket
+	var now time.Time
+
+	query = "SELECT tokens, updated_at, NOW() FROM rate_limit_buckets WHERE key = $1 FOR UPDATE"
+	if err := tx.QueryRowxContext(ctx, query, key).Scan(&bucket.Tokens, &bucket.UpdatedAt, &now); err != nil {
+		return model.RateLimitResult{}, err
+	}
+
+	bucket, result := limit.Take(bucket, now)
+
+	query = "UPDATE rate_limit_buckets SET tokens = $1, updated_at = $2, expires_at = $3 WHERE key = $4"
+	if err := execQuery(ctx, tx, query, bucket.Tokens, bucket.UpdatedAt, now.Add(result.Reset), key); err != nil {
+		return model.RateLimitResult{}, err
+	}
+
+	return result, nil
+}
+
+// sweep deletes full buckets at most once per rateLimitSweepInterval. Errors
+// are only logged since stale buckets do no harm.
+func (rl *RateLimiter) sweep(ctx context.Context) {
+	rl.mu.Lock()
+	if time.Since(rl.lastSweep) < rateLimitSweepInterval {
+		rl.mu.Unlock()
+		return
+	}
+	rl.lastSweep = time.Now()
+	rl.mu.Unlock()
+
+	if _, err := rl.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE expires_at < NOW()"); err != nil {
+		slog.ErrorContext(ctx, "error deleting expired rate limit buckets", "error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+// relatedCacheTTL bounds how stale recommendations get through changes that
+// do not invalidate the cache, such as new articles by other authors.
+const relatedCacheTTL = time.Hour
+
+// relatedMinScore drops candidates that have nothing meaningful in common.
+const relatedMinScore = 0.1
+
+type relatedEntry struct {
+	ids     []uint
+	expires time.Time
+}
+
+// relatedCache keeps the IDs of the articles related to an article. Only IDs
+// are cached so that responses always show the current titles.
+type relatedCache struct {
+	mu      sync.Mutex
+	entries map[uint]relatedEntry
+}
+
+func newRelatedCache() *relatedCache {
+	return &relatedCache{entries: map[uint]relatedEntry{}}
+}
+
+func (c *relatedCache) get(articleID uint, now time.Time) ([]uint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[articleID]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.ids, true
+}
+
+func (c *relatedCache) set(articleID uint, ids []uint, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[articleID] = relatedEntry{ids: ids, expires: now.Add(relatedCacheTTL)}
+}
+
+// invalidate drops the recommendations for articleID and every list that
+// recommends it.
+func (c *relatedCache) invalidate(articleID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, articleID)
+	for id, entry := range c.entries {
+		for _, related := range entry.ids {
+			if related == articleID {
+				delete(c.entries, id)
+				break
+			}
+		}
+	}
+}
+
+func (c *relatedCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[uint]relatedEntry{}
+}
+
+func (as *ArticleService) RelatedArticles(ctx context.Context, articleID uint, limit int) ([]*model.Article, error) {
+	ctx, span := startSpan(ctx, "ArticleService.RelatedArticles")
+	defer span.End()
+
+	tx, err := as.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	articles, err := as.relatedArticles(ctx, tx, articleID, limit)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return articles, tx.Commit()
+}
+
+func (as *ArticleService) relatedArticles(ctx context.Context, tx *sqlx.Tx, articleID uint, limit int) ([]*model.Article, error) {
+	now := time.Now()
+
+	ids, ok := as.related.get(articleID, now)
+	if !ok {
+		var err error
+		ids, err = findRelatedArticleIDs(ctx, tx, articleID, model.MaxRelatedArticles)
+		if err != nil {
+			return nil, err
+		}
+		as.related.set(articleID, ids, now)
+	}
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	return findArticlesByIDs(ctx, tx, ids)
+}
+
+// findArticlesByIDs returns the live articles with the given IDs in the order
+// of ids. Articles trashed since the IDs were cached are left out.
+func findArticlesByIDs(ctx context.Context, tx *sqlx.Tx, ids []uint) ([]*model.Article, error) {
+	query := "SELECT * FROM articles WHERE id = ANY($1) AND deleted_at IS NULL"
+
+	found, err := queryArticles(ctx, tx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*model.Article, len(found))
+	for _, article := range found {
+		byID[article.ID] = article
+	}
+
+	articles := make([]*model.Article, 0, len(found))
+	for _, id := range ids {
+		if article, ok := byID[id]; ok {
+			articles = append(articles, article)
+		}
+	}
+
+	return articles, nil
+}
+
+// findRelatedArticleIDs ranks live articles by how much they have in common
+// with the article: shared authors, membership in the same series and the
+// trigram similarity of their titles and the beginning of their bodies.
+//
+// Only articles that share an author or a series with the article, or whose
+// title is similar enough for the % operator of pg_trgm, are scored. The
+// title match uses the trigram index, so the query does not have to compute
+// similarities for every article.
+func findRelatedArticleIDs(ctx context.Context, tx *sqlx.Tx, articleID uint, limit int) ([]uint, error) {
+	query := `
+	WITH source AS (
+		SELECT id, title, left(body, 2000) AS body FROM articles WHERE id = $1
+	), candidates AS (
+		SELECT id FROM articles WHERE title % (SELECT title FROM articles WHERE id = $1)
+		UNION
+		SELECT x.article_id FROM article_authors x JOIN article_authors y ON x.user_id = y.user_id
+		WHERE y.article_id = $1 AND x.accepted_at IS NOT NULL AND y.accepted_at IS NOT NULL
+		UNION
+		SELECT x.article_id FROM series_articles x JOIN series_articles y ON x.series_id = y.series_id
+		WHERE y.article_id = $1
+	), scored AS (
+		SELECT a.id, a.created_at,
+			2 * similarity(a.title, source.title)
+			+ similarity(left(a.body, 2000), source.body)
+			+ CASE WHEN EXISTS (
+				SELECT 1 FROM article_authors x JOIN article_authors y ON x.user_id = y.user_id
+				WHERE x.article_id = a.id AND y.article_id = source.id
+					AND x.accepted_at IS NOT NULL AND y.accepted_at IS NOT NULL
+			) THEN 1 ELSE 0 END
+			+ CASE WHEN EXISTS (
+				SELECT 1 FROM series_articles x JOIN series_articles y ON x.series_id = y.series_id
+				WHERE x.article_id = a.id AND y.article_id = source.id
+			) THEN 1 ELSE 0 END AS score
+		FROM articles a JOIN candidates c ON c.id = a.id, source
+		WHERE a.id <> source.id AND a.deleted_at IS NULL
+	)
+	SELECT id FROM scored
+	WHERE score >= $2
+	ORDER BY score DESC, created_at DESC
+	LIMIT $3`
+
+	ids := make([]uint, 0)
+	if err := tx.SelectContext(ctx, &ids, query, articleID, relatedMinScore, limit); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
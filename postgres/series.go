@@ -0,0 +1,289 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var _ model.SeriesService = (*SeriesService)(nil)
+
+type SeriesService struct {
+	db *DB
+}
+
+func NewSeriesService(db *DB) *SeriesService {
+	return &SeriesService{db}
+}
+
+func (ss *SeriesService) CreateSeries(ctx context.Context, series *model.Series, articleIDs []uint) error {
+	ctx, span := startSpan(ctx, "SeriesService.CreateSeries")
+	defer span.End()
+
+	tx, err := ss.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := createSeries(ctx, tx, series, articleIDs); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Sharing a series makes articles more related.
+	ss.db.related.clear()
+
+	return nil
+}
+
+func createSeries(ctx context.Context, tx *sqlx.Tx, series *model.Series, articleIDs []uint) error {
+	query := `
+	INSERT INTO series (title, slug, description, author_id)
+	VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+
+	args := []interface{}{series.Title, series.Slug, series.Description, series.AuthorID}
+
+	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&series.ID, &series.CreatedAt, &series.UpdatedAt); err != nil {
+		return seriesConstraintError(err)
+	}
+
+	if err := setSeriesArticles(ctx, tx, series.ID, articleIDs); err != nil {
+		return err
+	}
+
+	return attachSeriesArticles(ctx, tx, series)
+}
+
+func seriesConstraintError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "series_slug_key"`:
+		return model.ErrDuplicateSlug
+	case err.Error() == `pq: duplicate key value violates unique constraint "series_articles_article_id_key"`:
+		return model.ErrArticleInSeries
+	default:
+		return err
+	}
+}
+
+// setSeriesArticles replaces the members of the series with articleIDs, in order.
+func setSeriesArticles(ctx context.Context, tx *sqlx.Tx, seriesID uint, articleIDs []uint) error {
+	if err := execQuery(ctx, tx, "DELETE FROM series_articles WHERE series_id = $1", seriesID); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO series_articles (series_id, article_id, position) VALUES ($1, $2, $3)"
+	for i, articleID := range articleIDs {
+		if err := execQuery(ctx, tx, query, seriesID, articleID, i+1); err != nil {
+			return seriesConstraintError(err)
+		}
+	}
+
+	return nil
+}
+
+func (ss *SeriesService) SeriesBySlug(ctx context.Context, slug string) (*model.Series, error) {
+	ctx, span := startSpan(ctx, "SeriesService.SeriesBySlug")
+	defer span.End()
+
+	tx, err := ss.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := findSeriesBySlug(ctx, tx, slug)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return series, tx.Commit()
+}
+
+func findSeriesBySlug(ctx context.Context, tx *sqlx.Tx, slug string) (*model.Series, error) {
+	series, err := findSeries(ctx, tx, model.SeriesFilter{Slug: &slug})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(series) == 0 {
+		return nil, model.ErrNotFound
+	}
+
+	return series[0], nil
+}
+
+func (ss *SeriesService) Series(ctx context.Context, filter model.SeriesFilter) ([]*model.Series, error) {
+	ctx, span := startSpan(ctx, "SeriesService.Series")
+	defer span.End()
+
+	tx, err := ss.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := findSeries(ctx, tx, filter)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return series, tx.Commit()
+}
+
+func findSeries(ctx context.Context, tx *sqlx.Tx, filter model.SeriesFilter) ([]*model.Series, error) {
+	where, args := []string{}, []interface{}{}
+	argPosition := 0
+
+	if v := filter.ID; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("id = $%d", argPosition)), append(args, *v)
+	}
+
+	if v := filter.Slug; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("slug = $%d", argPosition)), append(args, *v)
+	}
+
+	if v := filter.AuthorUsername; v != nil {
+		argPosition++
+		clause := "author_id = (SELECT id FROM users WHERE username = $%d)"
+		where, args = append(where, fmt.Sprintf(clause, argPosition)), append(args, *v)
+	}
+
+	if v := filter.ArticleID; v != nil {
+		argPosition++
+		clause := "id IN (SELECT series_id FROM series_articles WHERE article_id = $%d)"
+		where, args = append(where, fmt.Sprintf(clause, argPosition)), append(args, *v)
+	}
+
+	query := "SELECT * FROM series" + formatWhereClause(where) + " ORDER BY created_at DESC " + formatLimitOffset(filter.Limit, filter.Offset)
+
+	series := make([]*model.Series, 0)
+	if err := findMany(ctx, tx, &series, query, args...); err != nil {
+		return nil, err
+	}
+
+	for _, s := range series {
+		if err := attachSeriesArticles(ctx, tx, s); err != nil {
+			return nil, err
+		}
+	}
+
+	return series, nil
+}
+
+// attachSeriesArticles loads the members of the series that are not in the
+// trash, in order and numbered from 1.
+func attachSeriesArticles(ctx context.Context, tx *sqlx.Tx, series *model.Series) error {
+	query := `
+	SELECT sa.series_id, sa.article_id, a.title, a.slug
+	FROM series_articles sa JOIN articles a ON a.id = sa.article_id
+	WHERE sa.series_id = $1 AND a.deleted_at IS NULL
+	ORDER BY sa.position ASC`
+
+	articles := make([]*model.SeriesArticle, 0)
+	if err := findMany(ctx, tx, &articles, query, series.ID); err != nil {
+		return fmt.Errorf("cannot find series articles: %w", err)
+	}
+
+	for i, article := range articles {
+		article.Position = i + 1
+	}
+
+	series.Articles = articles
+
+	return nil
+}
+
+func (ss *SeriesService) UpdateSeries(ctx context.Context, series *model.Series, patch model.SeriesPatch) error {
+	ctx, span := startSpan(ctx, "SeriesService.UpdateSeries")
+	defer span.End()
+
+	tx, err := ss.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := updateSeries(ctx, tx, series, patch); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if patch.ArticleIDs != nil {
+		ss.db.related.clear()
+	}
+
+	return nil
+}
+
+func updateSeries(ctx context.Context, tx *sqlx.Tx, series *model.Series, patch model.SeriesPatch) error {
+	if v := patch.Title; v != nil {
+		series.Title = *v
+	}
+
+	if v := patch.Description; v != nil {
+		series.Description = *v
+	}
+
+	query := `
+	UPDATE series SET title = $1, description = $2, updated_at = NOW()
+	WHERE id = $3
+	RETURNING updated_at`
+
+	args := []interface{}{series.Title, series.Description, series.ID}
+
+	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&series.UpdatedAt); err != nil {
+		return err
+	}
+
+	if v := patch.ArticleIDs; v != nil {
+		if err := setSeriesArticles(ctx, tx, series.ID, *v); err != nil {
+			return err
+		}
+	}
+
+	return attachSeriesArticles(ctx, tx, series)
+}
+
+func (ss *SeriesService) DeleteSeries(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "SeriesService.DeleteSeries")
+	defer span.End()
+
+	tx, err := ss.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := execQuery(ctx, tx, "DELETE FROM series WHERE id = $1", id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	ss.db.related.clear()
+
+	return nil
+}
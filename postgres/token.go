@@ -0,0 +1,176 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var _ model.TokenService = (*TokenService)(nil)
+
+type TokenService struct {
+	db *DB
+}
+
+func NewTokenService(db *DB) *TokenService {
+	return &TokenService{db}
+}
+
+func (ts *TokenService) CreateToken(ctx context.Context, token *model.Token) error {
+	ctx, span := startSpan(ctx, "TokenService.CreateToken")
+	defer span.End()
+
+	tx, err := ts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := createToken(ctx, tx, token); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createToken(ctx context.Context, tx *sqlx.Tx, token *model.Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES ($1, $2, $3, $4)`
+
+	return execQuery(ctx, tx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+}
+
+func (ts *TokenService) ConsumeToken(ctx context.Context, scope, plaintext string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "TokenService.ConsumeToken")
+	defer span.End()
+
+	tx, err := ts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := consumeToken(ctx, tx, scope, plaintext)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+func consumeToken(ctx context.Context, tx *sqlx.Tx, scope, plaintext string) (*model.User, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+	RETURNING user_id`
+
+	var userID uint
+	if err := tx.QueryRowxContext(ctx, query, model.HashToken(plaintext), scope).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return findUserByID(ctx, tx, userID)
+}
+
+func (ts *TokenService) TokenUser(ctx context.Context, scope, plaintext string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "TokenService.TokenUser")
+	defer span.End()
+
+	tx, err := ts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findTokenUser(ctx, tx, scope, plaintext)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+func findTokenUser(ctx context.Context, tx *sqlx.Tx, scope, plaintext string) (*model.User, error) {
+	query := "SELECT user_id FROM tokens WHERE hash = $1 AND scope = $2 AND expiry > NOW()"
+
+	var userID uint
+	if err := tx.QueryRowxContext(ctx, query, model.HashToken(plaintext), scope).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return findUserByID(ctx, tx, userID)
+}
+
+func (ts *TokenService) DeleteTokensForUser(ctx context.Context, scope string, userID uint) error {
+	ctx, span := startSpan(ctx, "TokenService.DeleteTokensForUser")
+	defer span.End()
+
+	tx, err := ts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteTokensForUser(ctx, tx, scope, userID); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteTokensForUser(ctx context.Context, tx *sqlx.Tx, scope string, userID uint) error {
+	query := "DELETE FROM tokens WHERE scope = $1 AND user_id = $2"
+
+	return execQuery(ctx, tx, query, scope, userID)
+}
+
+func (ts *TokenService) ResetPassword(ctx context.Context, plaintext, passwordHash string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "TokenService.ResetPassword")
+	defer span.End()
+
+	tx, err := ts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := resetPassword(ctx, tx, plaintext, passwordHash)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+func resetPassword(ctx context.Context, tx *sqlx.Tx, plaintext, passwordHash string) (*model.User, error) {
+	user, err := consumeToken(ctx, tx, model.ScopePasswordReset, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setPassword(ctx, tx, user, passwordHash); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
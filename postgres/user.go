@@ -2,8 +2,12 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/msksgm/go-techblog-msksgm/model"
@@ -18,6 +22,9 @@ func NewUserService(db *DB) *UserService {
 }
 
 func (us *UserService) CreateUser(ctx context.Context, user *model.User) error {
+	ctx, span := startSpan(ctx, "UserService.CreateUser")
+	defer span.End()
+
 	tx, err := us.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
@@ -33,27 +40,95 @@ func (us *UserService) CreateUser(ctx context.Context, user *model.User) error {
 }
 
 func createUser(ctx context.Context, tx *sqlx.Tx, user *model.User) error {
+	if err := checkUsernameRetired(ctx, tx, user.Username, user.ID); err != nil {
+		return err
+	}
+
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+
 	query := `
-		INSERT INTO users (username, password_hash)
-		VALUES ($1, $2) RETURNING id, created_at, updated_at
+		INSERT INTO users (username, email, password_hash, verified_at, role)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at
 	`
-	args := []interface{}{user.Username, user.PasswordHash}
+	args := []interface{}{user.Username, user.Email, user.PasswordHash, user.VerifiedAt, user.Role}
 	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
-			return model.ErrDuplicateUsername
-		default:
-			return err
-		}
+		return userConstraintError(err)
 	}
 
 	return nil
 }
 
+func (us *UserService) CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.Identity) error {
+	ctx, span := startSpan(ctx, "UserService.CreateUserWithIdentity")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := createUserWithIdentity(ctx, tx, user, identity); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createUserWithIdentity(ctx context.Context, tx *sqlx.Tx, user *model.User, identity *model.Identity) error {
+	if err := createUser(ctx, tx, user); err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	query := `
+	INSERT INTO user_identities (user_id, issuer, subject)
+	VALUES ($1, $2, $3) RETURNING id, created_at`
+
+	args := []interface{}{identity.UserID, identity.Issuer, identity.Subject}
+
+	return tx.QueryRowxContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+func userConstraintError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+		return model.ErrDuplicateUsername
+	case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		return model.ErrDuplicateEmail
+	default:
+		return err
+	}
+}
+
+// dummyUser is checked against when the username is unknown so that the
+// response takes as long as for a wrong password.
+var (
+	dummyUser     model.User
+	dummyUserOnce sync.Once
+)
+
 func (us *UserService) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.Authenticate")
+	defer span.End()
+
 	user, err := us.UserByUsername(ctx, username)
 	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			dummyUserOnce.Do(func() {
+				if err := dummyUser.SetPassword("dummy-password"); err != nil {
+					slog.ErrorContext(ctx, "error hashing dummy password", "error", err)
+				}
+			})
+			dummyUser.VerifyPassword(password)
+			return nil, model.ErrUnAuthorized
+		}
 		return nil, err
 	}
 
@@ -61,10 +136,36 @@ func (us *UserService) Authenticate(ctx context.Context, username, password stri
 		return nil, model.ErrUnAuthorized
 	}
 
+	if user.PasswordNeedsRehash() {
+		if err := us.rehashPassword(ctx, user, password); err != nil {
+			slog.ErrorContext(ctx, "error rehashing password", "user_id", user.ID, "error", err)
+		}
+	}
+
 	return user, nil
 }
 
+// rehashPassword replaces the stored hash with one from the preferred hasher.
+// The update is skipped if the hash changed since it was read.
+func (us *UserService) rehashPassword(ctx context.Context, user *model.User, password string) error {
+	oldHash := user.PasswordHash
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`
+	if _, err := us.db.ExecContext(ctx, query, user.PasswordHash, user.ID, oldHash); err != nil {
+		user.PasswordHash = oldHash
+		return err
+	}
+
+	return nil
+}
+
 func (us *UserService) UserByUsername(ctx context.Context, username string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.UserByUsername")
+	defer span.End()
+
 	tx, err := us.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -85,6 +186,130 @@ func (us *UserService) UserByUsername(ctx context.Context, username string) (*mo
 	return user, nil
 }
 
+func (us *UserService) Users(ctx context.Context, filter model.UserFilter) ([]*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.Users")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := findUsers(ctx, tx, filter)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (us *UserService) UserByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.UserByEmail")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findOneUser(ctx, tx, model.UserFilter{Email: &email})
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (us *UserService) UserByPreviousUsername(ctx context.Context, username string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.UserByPreviousUsername")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findUserByPreviousUsername(ctx, tx, username)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func findUserByPreviousUsername(ctx context.Context, tx *sqlx.Tx, username string) (*model.User, error) {
+	query := "SELECT user_id FROM username_history WHERE username = $1"
+
+	var userID uint
+	if err := tx.QueryRowxContext(ctx, query, username).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return findUserByID(ctx, tx, userID)
+}
+
+func (us *UserService) UserByIdentity(ctx context.Context, issuer, subject string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserService.UserByIdentity")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findUserByIdentity(ctx, tx, issuer, subject)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func findUserByIdentity(ctx context.Context, tx *sqlx.Tx, issuer, subject string) (*model.User, error) {
+	query := "SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2"
+
+	var userID uint
+	if err := tx.QueryRowxContext(ctx, query, issuer, subject).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return findUserByID(ctx, tx, userID)
+}
+
 func findOneUser(ctx context.Context, tx *sqlx.Tx, filter model.UserFilter) (*model.User, error) {
 	users, err := findUsers(ctx, tx, filter)
 
@@ -111,6 +336,16 @@ func findUsers(ctx context.Context, tx *sqlx.Tx, filter model.UserFilter) ([]*mo
 		where, args = append(where, fmt.Sprintf("username = $%d", argPosition)), append(args, *v)
 	}
 
+	if v := filter.Email; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("email = $%d", argPosition)), append(args, *v)
+	}
+
+	if v := filter.Role; v != nil {
+		argPosition++
+		where, args = append(where, fmt.Sprintf("role = $%d", argPosition)), append(args, *v)
+	}
+
 	query := "SELECT * from users" + formatWhereClause(where) + " ORDER BY id ASC" + formatLimitOffset(filter.Limit, filter.Offset)
 
 	users, err := queryUsers(ctx, tx, query, args...)
@@ -132,9 +367,12 @@ func queryUsers(ctx context.Context, tx *sqlx.Tx, query string, args ...interfac
 }
 
 func (us *UserService) UpdateUser(ctx context.Context, user *model.User, patch model.UserPatch) error {
+	ctx, span := startSpan(ctx, "UserService.UpdateUser")
+	defer span.End()
+
 	tx, err := us.db.BeginTxx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "error updating user", "error", err)
 		return model.ErrInternal
 	}
 
@@ -142,11 +380,16 @@ func (us *UserService) UpdateUser(ctx context.Context, user *model.User, patch m
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return rollbackErr
 		}
-		return model.ErrInternal
+		switch {
+		case errors.Is(err, model.ErrDuplicateUsername), errors.Is(err, model.ErrDuplicateEmail):
+			return err
+		default:
+			return model.ErrInternal
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "error updating user", "error", err)
 		return model.ErrInternal
 	}
 
@@ -154,34 +397,378 @@ func (us *UserService) UpdateUser(ctx context.Context, user *model.User, patch m
 }
 
 func updateUser(ctx context.Context, tx *sqlx.Tx, user *model.User, patch model.UserPatch) error {
-	if v := patch.Username; v != nil {
+	previousUsername := user.Username
+	if v := patch.Username; v != nil && *v != user.Username {
+		if err := checkUsernameRetired(ctx, tx, *v, user.ID); err != nil {
+			return err
+		}
+
+		now := time.Now()
 		user.Username = *v
+		user.UsernameChangedAt = &now
+	}
+
+	if v := patch.Email; v != nil {
+		user.Email = *v
 	}
 
 	if v := patch.PasswordHash; v != nil {
 		user.PasswordHash = *v
 	}
 
+	if v := patch.VerifiedAt; v != nil {
+		user.VerifiedAt = v
+	}
+
+	if v := patch.TOTPSecret; v != nil {
+		user.TOTPSecret = *v
+	}
+
+	if v := patch.Role; v != nil {
+		user.Role = *v
+	}
+
 	args := []interface{}{
 		user.Username,
+		user.Email,
 		user.PasswordHash,
+		user.VerifiedAt,
+		user.TOTPSecret,
+		user.UsernameChangedAt,
+		user.Role,
 		user.ID,
 	}
 
 	query := `
 	UPDATE users
-	SET username = $1, password_hash=$2, updated_at=NOW()
-	WHERE id = $3
+	SET username = $1, email = $2, password_hash=$3, verified_at = $4, totp_secret = $5, username_changed_at = $6, role = $7, updated_at=NOW()
+	WHERE id = $8
 	RETURNING updated_at`
 
 	if err := tx.QueryRowxContext(ctx, query, args...).Scan(&user.UpdatedAt); err != nil {
-		log.Printf("error updating record: %v", err)
-		return model.ErrInternal
+		switch err := userConstraintError(err); {
+		case errors.Is(err, model.ErrDuplicateUsername), errors.Is(err, model.ErrDuplicateEmail):
+			return err
+		default:
+			slog.ErrorContext(ctx, "error updating record", "error", err)
+			return model.ErrInternal
+		}
+	}
+
+	if user.Username != previousUsername {
+		if err := recordUsernameChange(ctx, tx, user.ID, previousUsername, user.Username); err != nil {
+			slog.ErrorContext(ctx, "error recording username change", "error", err)
+			return model.ErrInternal
+		}
+	}
+
+	return nil
+}
+
+func (us *UserService) SetPassword(ctx context.Context, user *model.User, passwordHash string) error {
+	ctx, span := startSpan(ctx, "UserService.SetPassword")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := setPassword(ctx, tx, user, passwordHash); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setPassword replaces the password of user and bumps the session version,
+// which invalidates every token issued before.
+func setPassword(ctx context.Context, tx *sqlx.Tx, user *model.User, passwordHash string) error {
+	query := `
+	UPDATE users
+	SET password_hash = $1, session_version = session_version + 1, updated_at = NOW()
+	WHERE id = $2
+	RETURNING password_hash, session_version, updated_at`
+
+	if err := tx.QueryRowxContext(ctx, query, passwordHash, user.ID).Scan(&user.PasswordHash, &user.SessionVersion, &user.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrNotFound
+		}
+		return err
+	}
+
+	// Reset links mailed before must not outlive the new password.
+	return deleteTokensForUser(ctx, tx, model.ScopePasswordReset, user.ID)
+}
+
+// checkUsernameRetired returns ErrDuplicateUsername if username used to
+// belong to a user other than userID, so that redirects from it stay
+// unambiguous.
+func checkUsernameRetired(ctx context.Context, tx *sqlx.Tx, username string, userID uint) error {
+	query := "SELECT EXISTS (SELECT 1 FROM username_history WHERE username = $1 AND user_id <> $2)"
+
+	var retired bool
+	if err := tx.QueryRowxContext(ctx, query, username, userID).Scan(&retired); err != nil {
+		return err
+	}
+
+	if retired {
+		return model.ErrDuplicateUsername
 	}
 
 	return nil
 }
 
+// recordUsernameChange keeps previous in the history of userID. A user who
+// takes back one of their previous usernames no longer redirects from it.
+func recordUsernameChange(ctx context.Context, tx *sqlx.Tx, userID uint, previous, current string) error {
+	query := "DELETE FROM username_history WHERE username = $1 AND user_id = $2"
+	if _, err := tx.ExecContext(ctx, query, current, userID); err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO username_history (user_id, username)
+	VALUES ($1, $2)
+	ON CONFLICT (username) DO UPDATE SET changed_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, userID, previous)
+	return err
+}
+
 func findUserByID(ctx context.Context, tx *sqlx.Tx, id uint) (*model.User, error) {
 	return findOneUser(ctx, tx, model.UserFilter{ID: &id})
 }
+
+func (us *UserService) DeleteUser(ctx context.Context, id uint, policy model.AuthoredContentPolicy) error {
+	ctx, span := startSpan(ctx, "UserService.DeleteUser")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteUser(ctx, tx, id, policy); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteUser(ctx context.Context, tx *sqlx.Tx, id uint, policy model.AuthoredContentPolicy) error {
+	switch policy {
+	case model.DeleteAuthoredContent:
+		if err := execQuery(ctx, tx, "DELETE FROM articles WHERE author_id = $1", id); err != nil {
+			return err
+		}
+	case model.AnonymizeAuthoredContent:
+		query := "UPDATE articles SET author_id = (SELECT id FROM users WHERE username = $1) WHERE author_id = $2"
+		if err := execQuery(ctx, tx, query, model.GhostUsername, id); err != nil {
+			return err
+		}
+
+		query = "UPDATE series SET author_id = (SELECT id FROM users WHERE username = $1) WHERE author_id = $2"
+		if err := execQuery(ctx, tx, query, model.GhostUsername, id); err != nil {
+			return err
+		}
+
+		// Co-authorships go away with the user, only the primary author of
+		// an article is replaced by the ghost.
+		query = `
+		UPDATE article_authors SET user_id = (SELECT id FROM users WHERE username = $1)
+		WHERE user_id = $2 AND position = 0`
+		if err := execQuery(ctx, tx, query, model.GhostUsername, id); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown authored content policy: %q", policy)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+func (us *UserService) EnableTwoFactor(ctx context.Context, userID uint, recoveryCodeHashes [][]byte) error {
+	ctx, span := startSpan(ctx, "UserService.EnableTwoFactor")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := enableTwoFactor(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func enableTwoFactor(ctx context.Context, tx *sqlx.Tx, userID uint, recoveryCodeHashes [][]byte) error {
+	query := "UPDATE users SET totp_enabled_at = NOW(), updated_at = NOW() WHERE id = $1 AND totp_secret <> ''"
+	if err := execQuery(ctx, tx, query, userID); err != nil {
+		return err
+	}
+
+	if err := execQuery(ctx, tx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		query := "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+		if err := execQuery(ctx, tx, query, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (us *UserService) UseRecoveryCode(ctx context.Context, userID uint, hash []byte) error {
+	ctx, span := startSpan(ctx, "UserService.UseRecoveryCode")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := useRecoveryCode(ctx, tx, userID, hash); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func useRecoveryCode(ctx context.Context, tx *sqlx.Tx, userID uint, hash []byte) error {
+	query := `
+	UPDATE recovery_codes SET used_at = NOW()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+func (us *UserService) UseTOTPStep(ctx context.Context, userID uint, step int64) error {
+	ctx, span := startSpan(ctx, "UserService.UseTOTPStep")
+	defer span.End()
+
+	tx, err := us.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := useTOTPStep(ctx, tx, userID, step); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// useTOTPStep only moves totp_last_step forward, so of two logins racing with
+// the same code only one succeeds.
+func useTOTPStep(ctx context.Context, tx *sqlx.Tx, userID uint, step int64) error {
+	query := `
+	UPDATE users SET totp_last_step = $1
+	WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`
+
+	result, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return model.ErrNotFound
+	}
+
+	return nil
+}
+
+func (us *UserService) ExportUser(ctx context.Context, userID uint) (*model.UserExport, error) {
+	ctx, span := startSpan(ctx, "UserService.ExportUser")
+	defer span.End()
+
+	// A repeatable read sees the profile and both article lists as of the
+	// same moment, so an article trashed meanwhile is neither lost nor
+	// exported twice.
+	tx, err := us.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	export, err := exportUser(ctx, tx, userID)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	return export, tx.Commit()
+}
+
+func exportUser(ctx context.Context, tx *sqlx.Tx, userID uint) (*model.UserExport, error) {
+	user, err := findUserByID(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	articles, err := findArticles(ctx, tx, model.ArticleFilter{AuthorUsername: &user.Username})
+	if err != nil {
+		return nil, err
+	}
+
+	trashed, err := findArticles(ctx, tx, model.ArticleFilter{AuthorUsername: &user.Username, Trashed: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.UserExport{User: user, Articles: append(articles, trashed...)}, nil
+}
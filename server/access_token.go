@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func (s *Server) listAccessTokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		filter := model.PersonalAccessTokenFilter{UserID: &user.ID}
+
+		tokens, err := s.accessTokenService.PersonalAccessTokens(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"tokens": tokens})
+	}
+}
+
+func (s *Server) createAccessToken() http.HandlerFunc {
+	type Input struct {
+		Token struct {
+			Name          string   `json:"name" validate:"required,max=255"`
+			Scopes        []string `json:"scopes" validate:"required,min=1"`
+			ExpiresInDays int      `json:"expiresInDays" validate:"min=0"`
+		} `json:"token" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.Token); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		for _, scope := range input.Token.Scopes {
+			if !model.IsPersonalAccessTokenScope(scope) {
+				msg := fmt.Sprintf("unknown scope %q, valid scopes are %v", scope, model.PersonalAccessTokenScopes)
+				errorResponse(w, http.StatusUnprocessableEntity, ErrorM{"scopes": []string{msg}})
+				return
+			}
+		}
+
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		var expiresAt *time.Time
+		if days := input.Token.ExpiresInDays; days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			expiresAt = &t
+		}
+
+		token, err := model.GeneratePersonalAccessToken(user.ID, input.Token.Name, input.Token.Scopes, expiresAt)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if err := s.accessTokenService.CreatePersonalAccessToken(r.Context(), token); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, M{"token": token})
+	}
+}
+
+func (s *Server) revokeAccessToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			err := ErrorM{"token": []string{"requested token not found"}}
+			notFoundError(w, err)
+			return
+		}
+		tokenID := uint(id)
+
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		filter := model.PersonalAccessTokenFilter{ID: &tokenID, UserID: &user.ID}
+		tokens, err := s.accessTokenService.PersonalAccessTokens(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if len(tokens) == 0 {
+			err := ErrorM{"token": []string{"requested token not found"}}
+			notFoundError(w, err)
+			return
+		}
+
+		if err := s.accessTokenService.RevokePersonalAccessToken(r.Context(), tokenID); err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"token": []string{"requested token is already revoked"}}
+				errorResponse(w, http.StatusConflict, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusNoContent, nil)
+	}
+}
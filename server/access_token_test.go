@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func Test_createAccessToken(t *testing.T) {
+	userStore := &mock.UserService{}
+	accessTokenStore := &mock.PersonalAccessTokenService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.accessTokenService = accessTokenStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+
+	var created *model.PersonalAccessToken
+	accessTokenStore.CreatePersonalAccessTokenFn = func(pat *model.PersonalAccessToken) error {
+		created = pat
+		return nil
+	}
+
+	input := `{
+		"token": {
+			"name": "ci",
+			"scopes": ["articles:read", "articles:write"]
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/tokens", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := struct {
+		Token struct {
+			Token string `json:"token"`
+		} `json:"token"`
+	}{}
+	if err := readJSON(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusCreated {
+		t.Errorf("expected status code of 201, but got %d", code)
+	}
+
+	if created == nil || !strings.HasPrefix(gotResp.Token.Token, model.PersonalAccessTokenPrefix) {
+		t.Errorf("expected a personal access token to be created and returned, but got %v", gotResp)
+	}
+}
+
+func Test_createAccessToken_unknownScope(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+
+	input := `{
+		"token": {
+			"name": "ci",
+			"scopes": ["admin"]
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/tokens", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code of 422, but got %d", code)
+	}
+}
+
+func Test_authenticate_accessTokenScopes(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	accessTokenStore := &mock.PersonalAccessTokenService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.accessTokenService = accessTokenStore
+
+	plaintext := model.PersonalAccessTokenPrefix + "token"
+	accessTokenStore.AuthenticatePersonalAccessTokenFn = func(s string) (*model.PersonalAccessToken, *model.User, error) {
+		if s != plaintext {
+			return nil, nil, model.ErrUnAuthorized
+		}
+		pat := &model.PersonalAccessToken{ID: 1, Scopes: []string{model.ScopeArticlesRead}}
+		return pat, &model.User{ID: 1, Username: "username"}, nil
+	}
+	articleStore.ArticlesFn = func() ([]*model.Article, error) {
+		return []*model.Article{}, nil
+	}
+	articleStore.CreateArticleFn = func(a *model.Article) error {
+		t.Error("expected article not to be created")
+		return nil
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		token  string
+		code   int
+	}{
+		{http.MethodGet, "/api/v1/articles", "", plaintext, http.StatusOK},
+		{http.MethodPost, "/api/v1/articles", `{"article": {"title": "t", "body": "b", "slug": "s"}}`, plaintext, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/user/tokens", "", plaintext, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/articles", "", model.PersonalAccessTokenPrefix + "revoked", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", tt.token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		if code := w.Code; code != tt.code {
+			t.Errorf("%s %s: expected status code of %d, but got %d", tt.method, tt.target, tt.code, code)
+		}
+	}
+}
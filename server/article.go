@@ -2,8 +2,9 @@ package server
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/msksgm/go-techblog-msksgm/model"
@@ -13,13 +14,28 @@ func articleResponse(article *model.Article) M {
 	if article == nil {
 		return nil
 	}
-	return M{
+	resp := M{
 		"title":     article.Title,
 		"body":      article.Body,
 		"slug":      article.Slug,
 		"createdAt": article.CreatedAt.Format("2006-01-02T15:04:05Z"),
 		"updatedAt": article.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
+	if len(article.Authors) > 0 {
+		authors := make([]interface{}, 0, len(article.Authors))
+		for _, author := range article.Authors {
+			authors = append(authors, map[string]interface{}{"username": author.Username})
+		}
+		resp["authors"] = authors
+	}
+	return resp
+}
+
+// reservedSlugs are taken by fixed routes under /articles, which would shadow
+// articles using them.
+var reservedSlugs = map[string]bool{
+	"trash":       true,
+	"invitations": true,
 }
 
 func (s *Server) createArticle() http.HandlerFunc {
@@ -43,6 +59,12 @@ func (s *Server) createArticle() http.HandlerFunc {
 			return
 		}
 
+		if reservedSlugs[input.Article.Slug] {
+			err := ErrorM{"slug": []string{"this slug is reserved"}}
+			errorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
 		article := model.Article{
 			Title: input.Article.Title,
 			Body:  input.Article.Body,
@@ -51,7 +73,8 @@ func (s *Server) createArticle() http.HandlerFunc {
 
 		user, err := userFromContext(r.Context())
 		if err != nil {
-			log.Fatal(err)
+			serverError(w, err)
+			return
 		}
 		article.Author = user
 		article.AuthorID = user.ID
@@ -61,10 +84,22 @@ func (s *Server) createArticle() http.HandlerFunc {
 			return
 		}
 
+		if s.requireVerifiedEmail && !user.IsVerified() {
+			err := ErrorM{"email": []string{"you must verify your email address before publishing articles"}}
+			errorResponse(w, http.StatusForbidden, err)
+			return
+		}
+
 		if err := s.articleService.CreateArticle(r.Context(), &article); err != nil {
-			serverError(w, err)
+			switch {
+			case errors.Is(err, model.ErrDuplicateSlug):
+				duplicateSlugError(w)
+			default:
+				serverError(w, err)
+			}
 			return
 		}
+		s.metrics.articlesCreated.Inc()
 
 		writeJSON(w, http.StatusCreated, M{"article": article})
 	}
@@ -85,6 +120,22 @@ func (s *Server) listArticles() http.HandlerFunc {
 			return
 		}
 
+		// An author without articles may have been renamed, in which case
+		// links with the old username keep working.
+		if len(articles) == 0 && filter.AuthorUsername != nil {
+			redirected, err := s.redirectPreviousUsername(w, r, *filter.AuthorUsername, func(current string) string {
+				query.Set("author", current)
+				return r.URL.Path + "?" + query.Encode()
+			})
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			if redirected {
+				return
+			}
+		}
+
 		writeJSON(w, http.StatusOK, M{"articles": articles})
 	}
 }
@@ -108,12 +159,60 @@ func (s *Server) getArticle() http.HandlerFunc {
 
 		if len(articles) > 0 {
 			article = articles[0]
+
+			series, err := s.seriesService.Series(r.Context(), model.SeriesFilter{ArticleID: &article.ID})
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			if len(series) > 0 {
+				article.Series = series[0].Navigation(article.ID)
+			}
+
+			w.Header().Set("ETag", article.ETag())
 		}
 
 		writeJSON(w, http.StatusOK, M{"article": article})
 	}
 }
 
+const defaultRelatedArticles = 5
+
+func (s *Server) listRelatedArticles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultRelatedArticles
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > model.MaxRelatedArticles {
+				msg := fmt.Sprintf("must be between 1 and %d", model.MaxRelatedArticles)
+				validationError(w, ErrorM{"limit": []string{msg}})
+				return
+			}
+			limit = n
+		}
+
+		article, err := s.articleService.ArticleBySlug(r.Context(), mux.Vars(r)["slug"])
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"article": []string{"requested article not found"}}
+				notFoundError(w, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		articles, err := s.articleService.RelatedArticles(r.Context(), article.ID, limit)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"articles": articles})
+	}
+}
+
 func (s *Server) updateArticle() http.HandlerFunc {
 	type Input struct {
 		Article struct {
@@ -145,25 +244,37 @@ func (s *Server) updateArticle() http.HandlerFunc {
 
 		user, err := userFromContext(r.Context())
 		if err != nil {
-			log.Fatal(err)
+			serverError(w, err)
+			return
 		}
 
-		if user.ID != article.AuthorID {
+		if !article.IsAuthor(user.ID) {
 			err := ErrorM{"article": []string{"forbidden request"}}
 			errorResponse(w, http.StatusForbidden, err)
 			return
 		}
 
+		if !ifMatch(r, article.ETag()) {
+			preconditionFailedError(w)
+			return
+		}
+
 		patch := model.ArticlePatch{
 			Title: input.Article.Title,
 			Body:  input.Article.Body,
 		}
 
 		if err := s.articleService.UpdateArticle(r.Context(), article, patch); err != nil {
-			serverError(w, err)
+			switch {
+			case errors.Is(err, model.ErrEditConflict):
+				preconditionFailedError(w)
+			default:
+				serverError(w, err)
+			}
 			return
 		}
 
+		w.Header().Set("ETag", article.ETag())
 		writeJSON(w, http.StatusOK, M{"article": article})
 	}
 }
@@ -186,20 +297,109 @@ func (s *Server) deleteArticle() http.HandlerFunc {
 
 		user, err := userFromContext(r.Context())
 		if err != nil {
-			log.Fatal(err)
+			serverError(w, err)
+			return
 		}
 
-		if user.ID != article.AuthorID {
+		if user.ID != article.AuthorID && !user.IsAdmin() {
 			err := ErrorM{"article": []string{"forbidden request"}}
 			errorResponse(w, http.StatusForbidden, err)
 			return
 		}
 
-		if err := s.articleService.DeleteArticle(r.Context(), article.ID); err != nil {
-			serverError(w, err)
+		if !ifMatch(r, article.ETag()) {
+			preconditionFailedError(w)
+			return
+		}
+
+		if err := s.articleService.DeleteArticle(r.Context(), article); err != nil {
+			switch {
+			case errors.Is(err, model.ErrEditConflict):
+				preconditionFailedError(w)
+			default:
+				serverError(w, err)
+			}
 			return
 		}
 
 		writeJSON(w, http.StatusNoContent, nil)
 	}
 }
+
+func (s *Server) listTrashedArticles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		filter := model.ArticleFilter{AuthorID: &user.ID, Trashed: true}
+
+		articles, err := s.articleService.Articles(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"articles": articles})
+	}
+}
+
+func (s *Server) restoreArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slug := mux.Vars(r)["slug"]
+		filter := model.ArticleFilter{Slug: &slug, Trashed: true}
+
+		articles, err := s.articleService.Articles(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if len(articles) == 0 {
+			err := ErrorM{"article": []string{"requested article not found in trash"}}
+			notFoundError(w, err)
+			return
+		}
+
+		// Trashed articles may share a slug, and restoring whichever comes
+		// first would leave the others unreachable.
+		if len(articles) > 1 {
+			err := ErrorM{"article": []string{"several articles with this slug are in trash"}}
+			errorResponse(w, http.StatusConflict, err)
+			return
+		}
+		article := articles[0]
+
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if user.ID != article.AuthorID && !user.IsAdmin() {
+			err := ErrorM{"article": []string{"forbidden request"}}
+			errorResponse(w, http.StatusForbidden, err)
+			return
+		}
+
+		if err := s.articleService.RestoreArticle(r.Context(), article.ID); err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"article": []string{"requested article not found in trash"}}
+				notFoundError(w, err)
+			case errors.Is(err, model.ErrDuplicateSlug):
+				duplicateSlugError(w)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		article.DeletedAt = nil
+
+		w.Header().Set("ETag", article.ETag())
+		writeJSON(w, http.StatusOK, M{"article": article})
+	}
+}
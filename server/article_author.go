@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/mail"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+// articleForAuthors loads the article named in the route for one of the
+// co-author handlers. It writes the error response and returns nil if the
+// article cannot be loaded.
+func (s *Server) articleForAuthors(w http.ResponseWriter, r *http.Request) *model.Article {
+	article, err := s.articleService.ArticleBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			err := ErrorM{"article": []string{"requested article not found"}}
+			notFoundError(w, err)
+		default:
+			serverError(w, err)
+		}
+		return nil
+	}
+
+	return article
+}
+
+func (s *Server) inviteCoAuthor() http.HandlerFunc {
+	type Input struct {
+		Author struct {
+			Username string `json:"username" validate:"required"`
+		} `json:"author" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.Author); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		article := s.articleForAuthors(w, r)
+		if article == nil {
+			return
+		}
+
+		if user.ID != article.AuthorID {
+			err := ErrorM{"article": []string{"only the primary author can invite co-authors"}}
+			errorResponse(w, http.StatusForbidden, err)
+			return
+		}
+
+		invitee, err := s.userService.UserByUsername(ctx, input.Author.Username)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"author": []string{"requested user not found"}}
+				notFoundError(w, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		if err := s.articleService.InviteCoAuthor(ctx, article.ID, invitee.ID); err != nil {
+			switch {
+			case errors.Is(err, model.ErrDuplicateAuthor):
+				err := ErrorM{"author": []string{"is already an author or invited"}}
+				errorResponse(w, http.StatusConflict, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		if err := s.sendCoAuthorInvitation(ctx, user, invitee, article); err != nil {
+			slog.ErrorContext(ctx, "error sending co-author invitation", "error", err)
+		}
+
+		writeJSON(w, http.StatusCreated, M{"invitation": M{"article": article.Slug, "username": invitee.Username}})
+	}
+}
+
+func (s *Server) sendCoAuthorInvitation(ctx context.Context, inviter, invitee *model.User, article *model.Article) error {
+	if invitee.Email == "" {
+		return nil
+	}
+
+	link := fmt.Sprintf("%s/api/v1/articles/%s/authors/accept", s.publicURL, article.Slug)
+
+	msg := mail.Message{
+		To:      invitee.Email,
+		Subject: fmt.Sprintf("%s invited you to co-author %q", inviter.Username, article.Title),
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s invited you to co-author %q. Accept the invitation by sending a POST request to:\n\n%s\n",
+			invitee.Username, inviter.Username, article.Title, link,
+		),
+	}
+
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *Server) acceptCoAuthorInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		article := s.articleForAuthors(w, r)
+		if article == nil {
+			return
+		}
+
+		if err := s.articleService.AcceptCoAuthorInvitation(ctx, article.ID, user.ID); err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"invitation": []string{"no pending invitation for this article"}}
+				notFoundError(w, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		article = s.articleForAuthors(w, r)
+		if article == nil {
+			return
+		}
+
+		w.Header().Set("ETag", article.ETag())
+		writeJSON(w, http.StatusOK, M{"article": article})
+	}
+}
+
+func (s *Server) removeCoAuthor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		article := s.articleForAuthors(w, r)
+		if article == nil {
+			return
+		}
+
+		username := mux.Vars(r)["username"]
+
+		// The primary author manages co-authors, everyone else can only
+		// leave an article or decline an invitation.
+		if user.ID != article.AuthorID && user.Username != username {
+			err := ErrorM{"article": []string{"forbidden request"}}
+			errorResponse(w, http.StatusForbidden, err)
+			return
+		}
+
+		coAuthor := user
+		if user.Username != username {
+			coAuthor, err = s.userService.UserByUsername(ctx, username)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrNotFound):
+					err := ErrorM{"author": []string{"requested user not found"}}
+					notFoundError(w, err)
+				default:
+					serverError(w, err)
+				}
+				return
+			}
+		}
+
+		if err := s.articleService.RemoveCoAuthor(ctx, article.ID, coAuthor.ID); err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"author": []string{"is not a co-author of this article"}}
+				notFoundError(w, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func (s *Server) listCoAuthorInvitations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		filter := model.ArticleFilter{InvitedUserID: &user.ID}
+
+		articles, err := s.articleService.Articles(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"articles": articles})
+	}
+}
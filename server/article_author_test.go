@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/mail"
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func Test_inviteCoAuthor(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	mailer := &mock.Mailer{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+	srv.mailer = mailer
+
+	users := map[string]*model.User{
+		"primary":  {ID: 1, Username: "primary"},
+		"coauthor": {ID: 2, Username: "coauthor", Email: "coauthor@example.com"},
+	}
+	userStore.UserByUsernameFn = func(username string) (*model.User, error) {
+		if u, ok := users[username]; ok {
+			return u, nil
+		}
+		return nil, model.ErrNotFound
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{ID: 10, Title: "title", Slug: "slug", AuthorID: 1}, nil
+	}
+
+	var invited [2]uint
+	articleStore.InviteCoAuthorFn = func(articleID, userID uint) error {
+		invited = [2]uint{articleID, userID}
+		return nil
+	}
+
+	var msg mail.Message
+	mailer.SendFn = func(m mail.Message) error {
+		msg = m
+		return nil
+	}
+
+	tests := []struct {
+		inviter string
+		code    int
+	}{
+		{"coauthor", http.StatusForbidden},
+		{"primary", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		token, err := srv.generateUserToken(users[tt.inviter])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := `{"author": {"username": "coauthor"}}`
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/articles/slug/authors", strings.NewReader(input))
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		if code := w.Code; code != tt.code {
+			t.Errorf("%s: expected status code of %d, but got %d", tt.inviter, tt.code, code)
+		}
+	}
+
+	if invited != [2]uint{10, 2} {
+		t.Errorf("expected user 2 to be invited to article 10, but got %v", invited)
+	}
+
+	if msg.To != "coauthor@example.com" || !strings.Contains(msg.Body, "/api/v1/articles/slug/authors/accept") {
+		t.Errorf("expected an invitation to be mailed to coauthor@example.com, but got %v", msg)
+	}
+}
+
+func Test_updateArticle_coAuthor(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	coAuthor := &model.User{ID: 2, Username: "coauthor"}
+	token, err := srv.generateUserToken(coAuthor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return coAuthor
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{
+			Title:    "title",
+			Body:     "body",
+			Slug:     "slug",
+			AuthorID: 1,
+			Authors: []*model.ArticleAuthor{
+				{UserID: 1, Username: "primary"},
+				{UserID: 2, Username: "coauthor", Position: 1},
+			},
+		}, nil
+	}
+
+	updated := false
+	articleStore.UpdateArticleFn = func(a *model.Article) error {
+		updated = true
+		return nil
+	}
+
+	input := `{"article": {"title": "title_updated"}}`
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/articles/slug", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if !updated {
+		t.Error("expected the co-author to be able to update the article")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/msksgm/go-techblog-msksgm/mock"
 	"github.com/msksgm/go-techblog-msksgm/model"
@@ -21,7 +22,7 @@ func Test_createArticle(t *testing.T) {
 	srv.articleService = articleStore
 	srv.userService = userStore
 
-	token, err := generateUserToken(
+	token, err := srv.generateUserToken(
 		&model.User{
 			ID:       1,
 			Username: "username",
@@ -74,6 +75,140 @@ func Test_createArticle(t *testing.T) {
 	}
 }
 
+func Test_createArticle_duplicateSlug(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"article": {
+			"title": "title",
+			"body": "body",
+			"slug": "slug"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/articles", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username", Token: token}
+	}
+
+	articleStore.CreateArticleFn = func(a *model.Article) error {
+		return model.ErrDuplicateSlug
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusConflict {
+		t.Errorf("expected status code of 409, but got %d", code)
+	}
+}
+
+func Test_createArticle_reservedSlug(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username", Token: token}
+	}
+
+	articleStore.CreateArticleFn = func(a *model.Article) error {
+		t.Errorf("expected article with slug %q not to be created", a.Slug)
+		return nil
+	}
+
+	for _, slug := range []string{"trash", "invitations"} {
+		input := `{
+			"article": {
+				"title": "title",
+				"body": "body",
+				"slug": "` + slug + `"
+			}
+		}`
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/articles", strings.NewReader(input))
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		if code := w.Code; code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code of 422, but got %d", slug, code)
+		}
+	}
+}
+
+func Test_createArticle_unverifiedEmail(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+	srv.requireVerifiedEmail = true
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"article": {
+			"title": "title",
+			"body": "body",
+			"slug": "slug"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/articles", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+	articleStore.CreateArticleFn = func(a *model.Article) error {
+		t.Error("expected article not to be created")
+		return nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusForbidden {
+		t.Errorf("expected status code of 403, but got %d", code)
+	}
+}
+
 // func Test_listArticles(t *testing.T) {
 // 	articleStore := &mock.ArticleService{}
 // 	userStore := &mock.UserService{}
@@ -81,7 +216,7 @@ func Test_createArticle(t *testing.T) {
 // 	srv.articleService = articleStore
 // 	srv.userService = userStore
 
-// 	token, err := generateUserToken(
+// 	token, err := srv.generateUserToken(
 // 		&model.User{
 // 			ID:       1,
 // 			Username: "username",
@@ -141,11 +276,17 @@ func Test_createArticle(t *testing.T) {
 func Test_getArticle(t *testing.T) {
 	articleStore := &mock.ArticleService{}
 	userStore := &mock.UserService{}
+	seriesStore := &mock.SeriesService{}
 	srv := testServer()
 	srv.articleService = articleStore
 	srv.userService = userStore
+	srv.seriesService = seriesStore
 
-	token, err := generateUserToken(
+	seriesStore.SeriesFn = func(f model.SeriesFilter) ([]*model.Series, error) {
+		return nil, nil
+	}
+
+	token, err := srv.generateUserToken(
 		&model.User{
 			ID:       1,
 			Username: "username",
@@ -190,6 +331,10 @@ func Test_getArticle(t *testing.T) {
 		t.Errorf("expected status code of 200, but got %d", code)
 	}
 
+	if etag := w.Header().Get("ETag"); etag != articles[0].ETag() {
+		t.Errorf("expected ETag %s, but got %s", articles[0].ETag(), etag)
+	}
+
 	if !reflect.DeepEqual(expectedResp, gotResp) {
 		t.Errorf("expected response %v, but got %v", expectedResp, gotResp)
 	}
@@ -202,7 +347,7 @@ func Test_getArticle(t *testing.T) {
 // 	srv.articleService = articleStore
 // 	srv.userService = userStore
 
-// 	token, err := generateUserToken(
+// 	token, err := srv.generateUserToken(
 // 		&model.User{
 // 			ID:       1,
 // 			Username: "username",
@@ -269,7 +414,7 @@ func Test_deleteArticle(t *testing.T) {
 	srv.articleService = articleStore
 	srv.userService = userStore
 
-	token, err := generateUserToken(
+	token, err := srv.generateUserToken(
 		&model.User{
 			ID:       1,
 			Username: "username",
@@ -310,6 +455,367 @@ func Test_deleteArticle(t *testing.T) {
 	}
 }
 
+func Test_deleteArticle_permissions(t *testing.T) {
+	tests := []struct {
+		name string
+		role model.Role
+		code int
+	}{
+		{"other user", model.RoleUser, http.StatusForbidden},
+		{"admin", model.RoleAdmin, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			articleStore := &mock.ArticleService{}
+			userStore := &mock.UserService{}
+			srv := testServer()
+			srv.articleService = articleStore
+			srv.userService = userStore
+
+			token, err := srv.generateUserToken(&model.User{ID: 2, Username: "other"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/articles/slug", nil)
+			req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+			w := httptest.NewRecorder()
+
+			userStore.GetCurrentUserFn = func() *model.User {
+				return &model.User{ID: 2, Username: "other", Role: tt.role}
+			}
+
+			articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+				return &model.Article{Title: "title", Body: "body", Slug: "slug", AuthorID: 1}, nil
+			}
+
+			deleted := false
+			articleStore.DeleteArticleFn = func() error {
+				deleted = true
+				return nil
+			}
+			srv.router.ServeHTTP(w, req)
+
+			if code := w.Code; code != tt.code {
+				t.Errorf("expected status code of %d, but got %d", tt.code, code)
+			}
+
+			if want := tt.code == http.StatusNoContent; deleted != want {
+				t.Errorf("expected deleted to be %v, but got %v", want, deleted)
+			}
+		})
+	}
+}
+
+func Test_updateArticle_preconditionFailed(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"article": {
+			"title": "title_updated"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/articles/slug", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	req.Header.Add("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username"}
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{Title: "title", Body: "body", Slug: "slug", Version: 2}, nil
+	}
+	articleStore.UpdateArticleFn = func(a *model.Article) error {
+		t.Error("expected article not to be updated")
+		return nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusPreconditionFailed {
+		t.Errorf("expected status code of 412, but got %d", code)
+	}
+}
+
+func Test_updateArticle_editConflict(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"article": {
+			"title": "title_updated"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/articles/slug", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	req.Header.Add("If-Match", `"2"`)
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username"}
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{Title: "title", Body: "body", Slug: "slug", Version: 2}, nil
+	}
+	articleStore.UpdateArticleFn = func(a *model.Article) error {
+		return model.ErrEditConflict
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusPreconditionFailed {
+		t.Errorf("expected status code of 412, but got %d", code)
+	}
+}
+
+func Test_deleteArticle_preconditionFailed(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/articles/slug", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	req.Header.Add("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username"}
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{Title: "title", Body: "body", Slug: "slug", Version: 2}, nil
+	}
+	articleStore.DeleteArticleFn = func() error {
+		t.Error("expected article not to be deleted")
+		return nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusPreconditionFailed {
+		t.Errorf("expected status code of 412, but got %d", code)
+	}
+}
+
+func Test_deleteArticle_concurrentUpdate(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/articles/slug", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	req.Header.Add("If-Match", `"2"`)
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{Username: "username"}
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{Title: "title", Body: "body", Slug: "slug", Version: 2}, nil
+	}
+	// The article was updated after it was read.
+	articleStore.DeleteArticleFn = func() error {
+		return model.ErrEditConflict
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusPreconditionFailed {
+		t.Errorf("expected status code of 412, but got %d", code)
+	}
+}
+
+func Test_listTrashedArticles(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/articles/trash", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+
+	deletedAt := time.Now()
+	articleStore.ArticlesFn = func() ([]*model.Article, error) {
+		return []*model.Article{{Title: "title", Body: "body", Slug: "slug", DeletedAt: &deletedAt}}, nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := struct {
+		Articles []M `json:"articles"`
+	}{}
+	if err := readJSON(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if len(gotResp.Articles) != 1 || gotResp.Articles[0]["deletedAt"] == nil {
+		t.Errorf("expected one trashed article, but got %v", gotResp.Articles)
+	}
+}
+
+func Test_restoreArticle(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/articles/slug/restore", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+
+	deletedAt := time.Now()
+	articleStore.ArticlesFn = func() ([]*model.Article, error) {
+		return []*model.Article{{Title: "title", Body: "body", Slug: "slug", AuthorID: 1, DeletedAt: &deletedAt}}, nil
+	}
+
+	restored := false
+	articleStore.RestoreArticleFn = func() error {
+		restored = true
+		return nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if !restored {
+		t.Error("expected article to be restored")
+	}
+}
+
+func Test_restoreArticle_ambiguousSlug(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/articles/slug/restore", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+
+	deletedAt := time.Now()
+	articleStore.ArticlesFn = func() ([]*model.Article, error) {
+		return []*model.Article{
+			{ID: 2, Title: "newer", Body: "body", Slug: "slug", AuthorID: 1, DeletedAt: &deletedAt},
+			{ID: 1, Title: "older", Body: "body", Slug: "slug", AuthorID: 1, DeletedAt: &deletedAt},
+		}, nil
+	}
+
+	articleStore.RestoreArticleFn = func() error {
+		t.Error("expected no article to be restored")
+		return nil
+	}
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusConflict {
+		t.Errorf("expected status code of 409, but got %d", code)
+	}
+}
+
 func extractResponseArticleBody(body io.Reader, v interface{}) error {
 	mm := M{}
 	_ = readJSON(body, &mm)
@@ -323,3 +829,62 @@ func extractResponseArticleBody(body io.Reader, v interface{}) error {
 	}
 	return nil
 }
+
+func Test_listRelatedArticles(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{ID: 7, Slug: "slug"}, nil
+	}
+
+	var gotLimit int
+	articleStore.RelatedArticlesFn = func(articleID uint, limit int) ([]*model.Article, error) {
+		if articleID != 7 {
+			t.Errorf("expected articles related to 7, but got %d", articleID)
+		}
+		gotLimit = limit
+		return []*model.Article{{Title: "related", Slug: "related"}}, nil
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		limit int
+	}{
+		{"", http.StatusOK, 5},
+		{"?limit=3", http.StatusOK, 3},
+		{"?limit=100", http.StatusUnprocessableEntity, 0},
+	}
+
+	for _, tt := range tests {
+		gotLimit = 0
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/articles/slug/related"+tt.query, nil)
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		if code := w.Code; code != tt.code {
+			t.Errorf("%q: expected status code of %d, but got %d", tt.query, tt.code, code)
+		}
+
+		if gotLimit != tt.limit {
+			t.Errorf("%q: expected limit %d, but got %d", tt.query, tt.limit, gotLimit)
+		}
+	}
+}
@@ -11,8 +11,10 @@ import (
 type contextKey string
 
 const (
-	userKey  contextKey = "user"
-	tokenKey contextKey = "token"
+	userKey        contextKey = "user"
+	tokenKey       contextKey = "token"
+	accessTokenKey contextKey = "accessToken"
+	clientIPKey    contextKey = "clientIP"
 )
 
 func setContextUser(r *http.Request, u *model.User) *http.Request {
@@ -44,3 +46,25 @@ func userTokenFromContext(ctx context.Context) string {
 
 	return token
 }
+
+func setContextAccessToken(r *http.Request, token *model.PersonalAccessToken) *http.Request {
+	ctx := context.WithValue(r.Context(), accessTokenKey, token)
+	return r.WithContext(ctx)
+}
+
+// accessTokenFromContext returns the personal access token the request was
+// authenticated with, or nil for a regular login session.
+func accessTokenFromContext(ctx context.Context) *model.PersonalAccessToken {
+	token, ok := ctx.Value(accessTokenKey).(*model.PersonalAccessToken)
+
+	if !ok {
+		return nil
+	}
+
+	return token
+}
+
+func setContextClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPKey, ip)
+	return r.WithContext(ctx)
+}
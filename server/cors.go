@@ -0,0 +1,240 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig decides which cross-origin requests browsers may make. CORS is
+// disabled when AllowedOrigins is empty.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as https://app.example.com. The host
+	// may start with a wildcard label to allow every subdomain, as in
+	// https://*.example.com, and "*" allows any origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read besides the
+	// CORS-safelisted ones.
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge time.Duration
+}
+
+// corsPolicy is a CORSConfig prepared for matching requests.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	// wildcards hold the scheme and the host suffix of wildcard origins,
+	// such as https:// and .example.com.
+	wildcards [][2]string
+
+	methods          map[string]bool
+	headers          map[string]bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORSPolicy(cfg CORSConfig) *corsPolicy {
+	if len(cfg.AllowedOrigins) == 0 {
+		return nil
+	}
+
+	p := &corsPolicy{
+		origins:          map[string]bool{},
+		methods:          map[string]bool{},
+		headers:          map[string]bool{},
+		allowMethods:     strings.Join(cfg.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, suffix, _ := strings.Cut(origin, "*")
+			p.wildcards = append(p.wildcards, [2]string{scheme, suffix})
+		default:
+			p.origins[origin] = true
+		}
+	}
+
+	for _, method := range cfg.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+
+	for _, header := range cfg.AllowedHeaders {
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+
+	for _, w := range p.wildcards {
+		scheme, suffix := w[0], w[1]
+		if !strings.HasPrefix(origin, scheme) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		if sub := strings.TrimSuffix(strings.TrimPrefix(origin, scheme), suffix); validSubdomain(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validSubdomain accepts the labels a wildcard stands for, so that a wildcard
+// cannot match a different host or port.
+func validSubdomain(s string) bool {
+	if s == "" || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// allowRequestHeaders reports whether all headers in a preflight's
+// Access-Control-Request-Headers may be sent.
+func (p *corsPolicy) allowRequestHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// cors adds the CORS headers to responses to allowed origins. It must be
+// installed on the router, and preflight requests are answered by the
+// preflight route. Responses vary by Origin whenever CORS is enabled, so that
+// caches do not hand the response for one origin to another.
+func (s *Server) cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.corsPolicy == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !s.corsPolicy.allowOrigin(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The literal * cannot be combined with credentials, which are
+		// refused for it by the configuration.
+		if s.corsPolicy.anyOrigin && !s.corsPolicy.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if s.corsPolicy.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !isPreflight(r) && s.corsPolicy.exposeHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", s.corsPolicy.exposeHeaders)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// routeMethods are the methods tried to find out which ones a path supports.
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// preflight answers OPTIONS requests to every route with the methods the
+// route supports in the Allow header. For preflight requests it grants the
+// requested method and headers if the CORS configuration allows them, and
+// answers without granting anything otherwise, which makes the browser
+// refuse the request.
+func (s *Server) preflight() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var methods []string
+		for _, method := range routeMethods {
+			probe := *r
+			probe.Method = method
+
+			var match mux.RouteMatch
+			if s.router.Match(&probe, &match) && match.MatchErr == nil {
+				methods = append(methods, method)
+			}
+		}
+
+		if len(methods) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+
+		if s.corsPolicy != nil && isPreflight(r) {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			routeAllows := false
+			for _, method := range methods {
+				routeAllows = routeAllows || method == requested
+			}
+
+			if w.Header().Get("Access-Control-Allow-Origin") != "" && routeAllows &&
+				s.corsPolicy.methods[requested] &&
+				s.corsPolicy.allowRequestHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+				w.Header().Set("Access-Control-Allow-Methods", s.corsPolicy.allowMethods)
+				if s.corsPolicy.allowHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", s.corsPolicy.allowHeaders)
+				}
+				if s.corsPolicy.maxAge != "" {
+					w.Header().Set("Access-Control-Max-Age", s.corsPolicy.maxAge)
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
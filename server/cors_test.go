@@ -0,0 +1,176 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func corsTestServer() *Server {
+	srv := testServer()
+	srv.corsPolicy = newCORSPolicy(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"ETag", "X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	return srv
+}
+
+func Test_cors_preflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		origin  string
+		method  string
+		headers string
+		granted bool
+	}{
+		{"allowed origin", "/api/v1/articles", "https://app.example.com", "POST", "content-type, authorization", true},
+		{"wildcard subdomain", "/api/v1/articles", "https://pr-42.preview.example.com", "POST", "", true},
+		{"nested wildcard subdomain", "/api/v1/articles", "https://a.b.preview.example.com", "GET", "", true},
+		{"wildcard apex", "/api/v1/articles", "https://preview.example.com", "POST", "", false},
+		{"wildcard other port", "/api/v1/articles", "https://pr-42.preview.example.com:8443", "POST", "", false},
+		{"wildcard other scheme", "/api/v1/articles", "http://pr-42.preview.example.com", "POST", "", false},
+		{"unknown origin", "/api/v1/articles", "https://evil.example.net", "POST", "", false},
+		{"method not on route", "/api/v1/user/tokens/1", "https://app.example.com", "POST", "", false},
+		{"header not allowed", "/api/v1/articles", "https://app.example.com", "POST", "X-Custom", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := corsTestServer()
+
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			w := httptest.NewRecorder()
+
+			srv.router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("expected status code %d, but got %d", http.StatusNoContent, w.Code)
+			}
+
+			granted := w.Header().Get("Access-Control-Allow-Methods") != ""
+			if granted != tt.granted {
+				t.Fatalf("expected the preflight to be granted: %v, but got headers %v", tt.granted, w.Header())
+			}
+
+			if !tt.granted {
+				return
+			}
+
+			want := map[string]string{
+				"Access-Control-Allow-Origin":      tt.origin,
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+				"Access-Control-Max-Age":           "600",
+			}
+			for header, value := range want {
+				if got := w.Header().Get(header); got != value {
+					t.Errorf("expected %s %q, but got %q", header, value, got)
+				}
+			}
+
+			vary := w.Header().Values("Vary")
+			for _, v := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+				if !contains(vary, v) {
+					t.Errorf("expected Vary to contain %s, but got %v", v, vary)
+				}
+			}
+		})
+	}
+}
+
+func Test_cors_request(t *testing.T) {
+	srv := corsTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("expected the origin to be allowed, but got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "ETag, X-Request-ID" {
+		t.Errorf("expected exposed headers, but got %q", got)
+	}
+	if !contains(w.Header().Values("Vary"), "Origin") {
+		t.Errorf("expected Vary: Origin, but got %v", w.Header().Values("Vary"))
+	}
+
+	// Responses for other origins must not be cached for allowed ones.
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://evil.example.net")
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin, but got %q", got)
+	}
+	if !contains(w.Header().Values("Vary"), "Origin") {
+		t.Errorf("expected Vary: Origin, but got %v", w.Header().Values("Vary"))
+	}
+}
+
+func Test_cors_anyOrigin(t *testing.T) {
+	srv := testServer()
+	srv.corsPolicy = newCORSPolicy(CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://anywhere.example.org")
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin *, but got %q", got)
+	}
+}
+
+func Test_options(t *testing.T) {
+	tests := []struct {
+		path  string
+		code  int
+		allow string
+	}{
+		{"/api/v1/articles/a-slug", http.StatusNoContent, "GET, PUT, PATCH, DELETE, OPTIONS"},
+		{"/api/v1/users/login", http.StatusNoContent, "POST, OPTIONS"},
+		{"/healthz", http.StatusNoContent, "GET, OPTIONS"},
+		{"/api/v1/nowhere", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// OPTIONS is answered whether CORS is enabled or not.
+			srv := testServer()
+
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, req)
+
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, but got %d", tt.code, w.Code)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("expected Allow %q, but got %q", tt.allow, got)
+			}
+		})
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
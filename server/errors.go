@@ -1,10 +1,15 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/msksgm/go-techblog-msksgm/model"
 	"gopkg.in/go-playground/validator.v9"
 )
 
@@ -26,6 +31,8 @@ func validationError(w http.ResponseWriter, _err error) {
 			msg := checkTagRules(e)
 			resp[field] = append(resp[field], msg)
 		}
+	case ErrorM:
+		resp = err
 	default:
 		resp["non_field_error"] = append(resp["non_field_error"], err.Error())
 	}
@@ -36,13 +43,29 @@ func badRequestError(w http.ResponseWriter) {
 	errorResponse(w, http.StatusUnprocessableEntity, "unable to process request")
 }
 
+// serverError logs err and responds with a generic message. The request ID
+// in the response lets the caller refer to the log line.
 func serverError(w http.ResponseWriter, err error) {
-	log.Println(err)
+	slog.Error("internal error", "error", err, "request_id", w.Header().Get(requestIDHeader))
 	errorResponse(w, http.StatusInternalServerError, "internal error")
 }
 
+// errorResponse writes errs in the error format of the API. It includes the
+// request ID that the requestID middleware set on w, if any.
 func errorResponse(w http.ResponseWriter, code int, errs interface{}) {
-	writeJSON(w, code, M{"errors": errs})
+	resp := M{"errors": errs}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		resp["requestId"] = id
+	}
+	writeJSON(w, code, resp)
+}
+
+func routeNotFoundError(w http.ResponseWriter) {
+	errorResponse(w, http.StatusNotFound, "the requested resource could not be found")
+}
+
+func methodNotAllowedError(w http.ResponseWriter) {
+	errorResponse(w, http.StatusMethodNotAllowed, "the method is not supported for this resource")
 }
 
 func invalidUserCredentialsError(w http.ResponseWriter) {
@@ -50,12 +73,25 @@ func invalidUserCredentialsError(w http.ResponseWriter) {
 	errorResponse(w, http.StatusUnauthorized, msg)
 }
 
+func tooManyRequestsError(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	msg := fmt.Sprintf("too many attempts, try again in %d seconds", seconds)
+	errorResponse(w, http.StatusTooManyRequests, msg)
+}
+
 func invalidAuthTokenError(w http.ResponseWriter) {
 	w.Header().Set("WWW-Authenticate", "Token")
 	msg := "invalid or missing authentication token"
 	errorResponse(w, http.StatusUnauthorized, msg)
 }
 
+func insufficientScopeError(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Token error="insufficient_scope", scope="%s"`, scope))
+	err := ErrorM{"token": []string{fmt.Sprintf("this token does not grant the %s scope", scope)}}
+	errorResponse(w, http.StatusForbidden, err)
+}
+
 func checkTagRules(e validator.FieldError) (errMsg string) {
 	tag, field, param := e.ActualTag(), e.Field(), e.Param()
 
@@ -70,9 +106,45 @@ func checkTagRules(e validator.FieldError) (errMsg string) {
 	if tag == "max" {
 		errMsg = fmt.Sprintf("%s must be less than %v", field, param)
 	}
+
+	if tag == "email" {
+		errMsg = fmt.Sprintf("%s must be a valid email address", field)
+	}
+
+	if tag == "oneof" {
+		errMsg = fmt.Sprintf("%s must be one of [%v]", field, param)
+	}
 	return
 }
 
+func preconditionFailedError(w http.ResponseWriter) {
+	msg := "the resource has been modified since it was last fetched"
+	errorResponse(w, http.StatusPreconditionFailed, ErrorM{"article": []string{msg}})
+}
+
+func duplicateUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateUsername):
+		err := ErrorM{"username": []string{"this username is already in use"}}
+		errorResponse(w, http.StatusConflict, err)
+	case errors.Is(err, model.ErrDuplicateEmail):
+		err := ErrorM{"email": []string{"this email is already in use"}}
+		errorResponse(w, http.StatusConflict, err)
+	default:
+		serverError(w, err)
+	}
+}
+
+func duplicateSlugError(w http.ResponseWriter) {
+	err := ErrorM{"slug": []string{"this slug is already in use"}}
+	errorResponse(w, http.StatusConflict, err)
+}
+
+func invalidPasswordResetTokenError(w http.ResponseWriter) {
+	err := ErrorM{"token": []string{"invalid or expired password reset token"}}
+	errorResponse(w, http.StatusUnprocessableEntity, err)
+}
+
 func notFoundError(w http.ResponseWriter, err ErrorM) {
 	errorResponse(w, http.StatusNotFound, err)
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+const exportTimeFormat = "2006-01-02T15:04:05Z"
+
+// writeUserExport writes a ZIP archive with the profile and articles of user to w.
+func writeUserExport(w io.Writer, user *model.User, articles []*model.Article) error {
+	zw := zip.NewWriter(w)
+
+	profile := M{
+		"username":  user.Username,
+		"createdAt": user.CreatedAt.UTC().Format(exportTimeFormat),
+		"updatedAt": user.UpdatedAt.UTC().Format(exportTimeFormat),
+	}
+
+	f, err := zw.Create("profile.json")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(profile); err != nil {
+		return err
+	}
+
+	for _, article := range articles {
+		f, err := zw.Create(exportArticleName(article))
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(f, articleMarkdown(article)); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// exportArticleName names the archive entry of article. Slugs are chosen by
+// users and trashed articles may share one, so the slug is stripped of
+// anything that could leave the articles directory when extracted and
+// prefixed with the unique article ID.
+func exportArticleName(article *model.Article) string {
+	slug := strings.NewReplacer("/", "-", "\\", "-").Replace(article.Slug)
+	for strings.Contains(slug, "..") {
+		slug = strings.ReplaceAll(slug, "..", "")
+	}
+	slug = strings.Trim(slug, "-.")
+
+	if slug == "" {
+		return fmt.Sprintf("articles/%d.md", article.ID)
+	}
+	return fmt.Sprintf("articles/%d-%s.md", article.ID, slug)
+}
+
+func articleMarkdown(article *model.Article) string {
+	var b strings.Builder
+
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(article.Title))
+	fmt.Fprintf(&b, "slug: %s\n", strconv.Quote(article.Slug))
+	fmt.Fprintf(&b, "createdAt: %s\n", article.CreatedAt.UTC().Format(exportTimeFormat))
+	fmt.Fprintf(&b, "updatedAt: %s\n", article.UpdatedAt.UTC().Format(exportTimeFormat))
+	if v := article.DeletedAt; v != nil {
+		fmt.Fprintf(&b, "deletedAt: %s\n", v.UTC().Format(exportTimeFormat))
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(article.Body)
+	if !strings.HasSuffix(article.Body, "\n") {
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func exportFilename(user *model.User, now time.Time) string {
+	return fmt.Sprintf("%s-%s.zip", user.Username, now.UTC().Format("20060102"))
+}
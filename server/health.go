@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/postgres"
+)
+
+// readinessTimeout bounds how long the readiness probe waits for a single
+// dependency.
+const readinessTimeout = 2 * time.Second
+
+// dependency is an external service the server needs to handle requests.
+type dependency interface {
+	// Check returns details about the dependency to report in the readiness
+	// probe, and an error if it cannot be used.
+	Check(ctx context.Context) (M, error)
+}
+
+type databaseDependency struct {
+	db *postgres.DB
+}
+
+func (d databaseDependency) Check(ctx context.Context) (M, error) {
+	if err := d.db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	version, dirty, err := d.db.MigrationVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read migration version: %w", err)
+	}
+
+	details := M{"migrationVersion": version}
+	if dirty {
+		return details, fmt.Errorf("migration %d is dirty", version)
+	}
+
+	return details, nil
+}
+
+func (s *Server) healthCheck() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if s.isShuttingDown() {
+			writeJSON(rw, http.StatusServiceUnavailable, M{
+				"status":  "unavailable",
+				"message": "shutting down",
+			})
+			return
+		}
+
+		resp := M{
+			"status":  "available",
+			"message": "health",
+		}
+		writeJSON(rw, http.StatusOK, resp)
+	})
+}
+
+// liveness reports that the process is up and serving requests. It does not
+// look at dependencies, so an outage of the database does not get the
+// process restarted.
+func (s *Server) liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{"status": "alive"})
+	}
+}
+
+// readiness reports whether the server can handle requests: every dependency
+// must be usable and the server must not be shutting down.
+func (s *Server) readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready := !s.isShuttingDown()
+
+		names := make([]string, 0, len(s.dependencies))
+		for name := range s.dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		dependencies := M{}
+		for _, name := range names {
+			status := s.checkDependency(r.Context(), name, s.dependencies[name])
+			if status["status"] != "up" {
+				ready = false
+			}
+			dependencies[name] = status
+		}
+
+		resp := M{"status": "ready", "dependencies": dependencies}
+		code := http.StatusOK
+
+		if !ready {
+			resp["status"] = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+		if s.isShuttingDown() {
+			resp["status"] = "shutting down"
+		}
+
+		writeJSON(w, code, resp)
+	}
+}
+
+// checkDependency reports the status of dep. The readiness probe is not
+// authenticated, so the reason a dependency is down is only logged.
+func (s *Server) checkDependency(ctx context.Context, name string, dep dependency) M {
+	checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := dep.Check(checkCtx)
+	if err != nil {
+		slog.ErrorContext(ctx, "dependency is down", "dependency", name, "error", err)
+		return M{"status": "down"}
+	}
+
+	status := M{}
+	for k, v := range details {
+		status[k] = v
+	}
+	status["latencyMs"] = time.Since(start).Milliseconds()
+	status["status"] = "up"
+	return status
+}
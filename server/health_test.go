@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type dependencyFunc func(ctx context.Context) (M, error)
+
+func (f dependencyFunc) Check(ctx context.Context) (M, error) {
+	return f(ctx)
+}
+
+func Test_readiness(t *testing.T) {
+	up := dependencyFunc(func(ctx context.Context) (M, error) {
+		return M{"migrationVersion": 16}, nil
+	})
+	down := dependencyFunc(func(ctx context.Context) (M, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	tests := []struct {
+		name         string
+		dependency   dependency
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+		wantDatabase string
+	}{
+		{"ready", up, false, http.StatusOK, "ready", "up"},
+		{"database down", down, false, http.StatusServiceUnavailable, "unavailable", "down"},
+		{"shutting down", up, true, http.StatusServiceUnavailable, "shutting down", "up"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testServer()
+			srv.dependencies = map[string]dependency{"database": tt.dependency}
+			if tt.shuttingDown {
+				srv.shuttingDown = 1
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			srv.router.ServeHTTP(w, req)
+
+			if code := w.Code; code != tt.wantCode {
+				t.Errorf("expected status code of %d, but got %d", tt.wantCode, code)
+			}
+
+			gotResp := struct {
+				Status       string
+				Dependencies map[string]M
+			}{}
+			if err := extractResponseBody(w.Body, &gotResp); err != nil {
+				t.Fatal(err)
+			}
+
+			if gotResp.Status != tt.wantStatus {
+				t.Errorf("expected status %q, but got %q", tt.wantStatus, gotResp.Status)
+			}
+
+			if got := gotResp.Dependencies["database"]["status"]; got != tt.wantDatabase {
+				t.Errorf("expected database status %q, but got %v", tt.wantDatabase, got)
+			}
+
+			if tt.wantDatabase == "down" && len(gotResp.Dependencies["database"]) != 1 {
+				t.Errorf("expected only the status of a down database, but got %v", gotResp.Dependencies["database"])
+			}
+		})
+	}
+}
+
+func Test_liveness_shuttingDown(t *testing.T) {
+	srv := testServer()
+	srv.shuttingDown = 1
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const articlePurgeInterval = time.Hour
+
+// StartArticlePurger periodically hard-deletes articles that have been in the
+// trash for longer than retention, along with login attempts that have left
+// the throttling window. It stops when ctx is done.
+func (s *Server) StartArticlePurger(ctx context.Context, retention time.Duration) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+
+		ticker := time.NewTicker(articlePurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			s.purgeArticles(ctx, retention)
+			s.purgeLoginAttempts(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// background runs fn in a goroutine that Run waits for when shutting down.
+// fn must not use the request context, which is canceled once the handler
+// returns.
+func (s *Server) background(fn func()) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				slog.Error("panic in background worker", "error", err)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+func (s *Server) purgeArticles(ctx context.Context, retention time.Duration) {
+	n, err := s.articleService.PurgeArticles(ctx, time.Now().Add(-retention))
+	if err != nil {
+		slog.ErrorContext(ctx, "error purging trashed articles", "error", err)
+		return
+	}
+
+	if n > 0 {
+		slog.InfoContext(ctx, "purged trashed articles", "count", n)
+	}
+}
+
+func (s *Server) purgeLoginAttempts(ctx context.Context) {
+	n, err := s.loginAttemptService.PurgeLoginAttempts(ctx, time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "error purging login attempts", "error", err)
+		return
+	}
+
+	if n > 0 {
+		slog.InfoContext(ctx, "purged login attempts", "count", n)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "techblog"
+
+// metrics holds the collectors exposed on /metrics. Each server has its own
+// registry so that tests can create as many servers as they like.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	articlesCreated prometheus.Counter
+	usersRegistered prometheus.Counter
+	loginsFailed    *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being served.",
+		}),
+		articlesCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "articles_created_total",
+			Help:      "Number of articles created.",
+		}),
+		usersRegistered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "users_registered_total",
+			Help:      "Number of users who signed up with a password.",
+		}),
+		loginsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_failed_total",
+			Help:      "Number of failed logins by the factor that was refused.",
+		}, []string{"factor"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Number of requests refused by a rate limit.",
+		}, []string{"limit"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.articlesCreated,
+		m.usersRegistered,
+		m.loginsFailed,
+		m.rateLimited,
+	)
+
+	return m
+}
+
+// registerDB exposes the connection pool statistics of db.
+func (m *metrics) registerDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records request counts and latency of router labelled with the
+// route template, such as /api/v1/articles/{slug}, so that the number of
+// series does not grow with the number of articles. It wraps the router
+// instead of being installed with Router.Use, which only runs for matched
+// routes, so requests that no route matches are counted as "unmatched".
+func (m *metrics) instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.MatchErr == nil && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		snoop := httpsnoop.CaptureMetrics(router, w, r)
+
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(snoop.Code)).Inc()
+		m.requestDuration.WithLabelValues(r.Method, route).Observe(snoop.Duration.Seconds())
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_metrics(t *testing.T) {
+	srv := testServer()
+
+	handler := srv.handler()
+
+	for _, path := range []string{"/api/v1/health", "/api/v1/articles/first-post", "/api/v1/articles/second-post", "/nowhere", "/api/v1/nowhere"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/metrics", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Fatalf("expected status code of 200, but got %d", code)
+	}
+
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`techblog_http_requests_total{code="200",method="GET",route="/api/v1/health"} 1`,
+		`techblog_http_requests_total{code="401",method="GET",route="/api/v1/articles/{slug}"} 2`,
+		`techblog_http_request_duration_seconds_count{method="GET",route="/api/v1/articles/{slug}"} 2`,
+		`techblog_http_requests_total{code="404",method="GET",route="unmatched"} 2`,
+		`techblog_http_requests_total{code="405",method="POST",route="unmatched"} 1`,
+		`techblog_http_requests_in_flight 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+
+	if strings.Contains(body, "first-post") || strings.Contains(body, "nowhere") {
+		t.Errorf("expected metrics to be labelled by route template, not by path")
+	}
+}
@@ -1,17 +1,137 @@
 package server
 
 import (
-	"io"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
 
-	"github.com/gorilla/handlers"
+	"github.com/felixge/httpsnoop"
+	"github.com/msksgm/go-techblog-msksgm/logging"
 	"github.com/msksgm/go-techblog-msksgm/model"
 )
 
-func Logger(w io.Writer) func(h http.Handler) http.Handler {
-	return (func(h http.Handler) http.Handler {
-		return handlers.LoggingHandler(w, h)
+const requestIDHeader = "X-Request-ID"
+
+// requestID tags every request with an ID, taken from the X-Request-ID header
+// if the caller sent a usable one and generated otherwise. The ID is echoed in
+// the response header, carried in the request context for logging and added
+// to error responses.
+func requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = newRequestID(); err != nil {
+				serverError(w, err)
+				return
+			}
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters, so a
+// caller cannot inject line breaks or huge values into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// resolveClientIP records the address of the client for logging, login
+// throttling and rate limiting. Requests from trusted proxies are attributed to
+// the rightmost X-Forwarded-For entry that is not a trusted proxy itself, as
+// anything left of it may have been made up by the client.
+func (s *Server) resolveClientIP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if len(s.trustedProxies) > 0 {
+			ip = forwardedClientIP(ip, r.Header.Values("X-Forwarded-For"), s.trustedProxies)
+		}
+		h.ServeHTTP(w, setContextClientIP(r, ip))
+	})
+}
+
+func forwardedClientIP(remote string, forwardedFor []string, trusted []netip.Prefix) string {
+	isTrusted := func(s string) bool {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !isTrusted(remote) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range forwardedFor {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	ip := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break
+		}
+		ip = hops[i]
+		if !isTrusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// logRequests writes an access log line for every request.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := httpsnoop.CaptureMetrics(h, w, r)
+
+		level := slog.LevelInfo
+		if m.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.Log(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", m.Code),
+			slog.Int64("bytes", m.Written),
+			slog.Duration("duration", m.Duration),
+			slog.String("remote_ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
 	})
 }
 
@@ -40,16 +160,54 @@ func (s *Server) authenticate(mustAuth bool) func(http.Handler) http.Handler {
 
 			token := ss[1]
 
-			claims, err := parseUserToken(token)
-			if err != nil {
+			if strings.HasPrefix(token, model.PersonalAccessTokenPrefix) {
+				accessToken, user, err := s.accessTokenService.AuthenticatePersonalAccessToken(r.Context(), token)
+				if err != nil {
+					switch {
+					case errors.Is(err, model.ErrUnAuthorized):
+						invalidAuthTokenError(w)
+					default:
+						serverError(w, err)
+					}
+					return
+				}
+
+				r = setContextUser(r, user)
+				r = setContextUserToken(r, token)
+				r = setContextAccessToken(r, accessToken)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := s.parseUserToken(token)
+			if err != nil || claims["purpose"] != nil {
+				invalidAuthTokenError(w)
+				return
+			}
+
+			username, ok := claims["username"].(string)
+			if !ok {
 				invalidAuthTokenError(w)
 				return
 			}
 
-			username := claims["username"].(string)
 			user, err := s.userService.UserByUsername(r.Context(), username)
 			if err != nil {
-				serverError(w, err)
+				switch {
+				case errors.Is(err, model.ErrNotFound):
+					invalidAuthTokenError(w)
+				default:
+					serverError(w, err)
+				}
+				return
+			}
+
+			// Tokens issued before the user was signed out everywhere carry
+			// an older session version. Tokens without one predate it and
+			// count as version 0.
+			session, _ := claims["session"].(float64)
+			if uint(session) != user.SessionVersion {
+				invalidAuthTokenError(w)
 				return
 			}
 
@@ -59,3 +217,30 @@ func (s *Server) authenticate(mustAuth bool) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// requireScope only lets requests through that were authenticated with a
+// login session or with a personal access token granting scope.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := accessTokenFromContext(r.Context()); token != nil && !token.HasScope(scope) {
+				insufficientScopeError(w, scope)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSession rejects requests authenticated with a personal access token,
+// which must not be able to manage the account they belong to.
+func requireSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := accessTokenFromContext(r.Context()); token != nil {
+			err := ErrorM{"token": []string{"this endpoint requires a login session"}}
+			errorResponse(w, http.StatusForbidden, err)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
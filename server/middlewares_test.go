@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_requestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"propagated", "req-1234", true},
+		{"refused", "bad id\nwith newline", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testServer()
+
+			// Requests to a protected route without a token fail, which
+			// shows the ID in the error body.
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/user", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			w := httptest.NewRecorder()
+
+			srv.handler().ServeHTTP(w, req)
+
+			id := w.Header().Get("X-Request-ID")
+			if id == "" {
+				t.Fatal("expected an X-Request-ID response header")
+			}
+
+			if tt.keep && id != tt.incoming {
+				t.Errorf("expected request ID %q, but got %q", tt.incoming, id)
+			}
+			if !tt.keep && id == tt.incoming {
+				t.Errorf("expected request ID %q to be replaced", tt.incoming)
+			}
+
+			gotResp := struct {
+				RequestID string `json:"requestId"`
+			}{}
+			if err := extractResponseBody(w.Body, &gotResp); err != nil {
+				t.Fatal(err)
+			}
+
+			if gotResp.RequestID != id {
+				t.Errorf("expected request ID %q in the error response, but got %q", id, gotResp.RequestID)
+			}
+		})
+	}
+}
+
+func Test_requestID_unmatchedRoute(t *testing.T) {
+	srv := testServer()
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/nowhere", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/nowhere", http.StatusNotFound},
+		{http.MethodPost, "/metrics", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+
+		srv.handler().ServeHTTP(w, req)
+
+		if code := w.Code; code != tt.code {
+			t.Errorf("%s %s: expected status code of %d, but got %d", tt.method, tt.path, tt.code, code)
+		}
+
+		id := w.Header().Get("X-Request-ID")
+		if id == "" {
+			t.Fatalf("%s %s: expected an X-Request-ID response header", tt.method, tt.path)
+		}
+
+		gotResp := struct {
+			RequestID string `json:"requestId"`
+		}{}
+		if err := extractResponseBody(w.Body, &gotResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if gotResp.RequestID != id {
+			t.Errorf("%s %s: expected request ID %q in the error response, but got %q", tt.method, tt.path, id, gotResp.RequestID)
+		}
+	}
+}
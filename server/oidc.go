@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/oidc"
+)
+
+const (
+	oidcCookieName     = "oidc_auth"
+	oidcCookiePath     = "/api/v1/auth/oidc"
+	oidcCookiePurpose  = "oidc"
+	oidcLoginTTL       = 10 * time.Minute
+	oidcUsernameTrials = 10
+)
+
+var oidcUsernameDisallowed = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+func oidcNotConfiguredError(w http.ResponseWriter) {
+	errorResponse(w, http.StatusNotFound, "single sign-on is not configured")
+}
+
+func (s *Server) oidcLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.oidc == nil {
+			oidcNotConfiguredError(w)
+			return
+		}
+
+		values := make([]string, 3)
+		for i := range values {
+			v, err := oidc.RandomString()
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			values[i] = v
+		}
+		state, nonce, verifier := values[0], values[1], values[2]
+
+		// The values needed to finish the login are kept in a signed cookie so
+		// that no server side session storage is needed.
+		cookie := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"purpose":  oidcCookiePurpose,
+			"state":    state,
+			"nonce":    nonce,
+			"verifier": verifier,
+			"exp":      time.Now().Add(oidcLoginTTL).Unix(),
+		})
+
+		value, err := cookie.SignedString(s.jwtSecret)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookieName,
+			Value:    value,
+			Path:     oidcCookiePath,
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(s.publicURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		http.Redirect(w, r, s.oidc.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+	}
+}
+
+func (s *Server) oidcCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.oidc == nil {
+			oidcNotConfiguredError(w)
+			return
+		}
+
+		query := r.URL.Query()
+
+		if v := query.Get("error"); v != "" {
+			msg := fmt.Sprintf("sign-in was rejected by the identity provider: %s", v)
+			errorResponse(w, http.StatusUnauthorized, msg)
+			return
+		}
+
+		cookie, err := r.Cookie(oidcCookieName)
+		if err != nil {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: oidcCookiePath, MaxAge: -1})
+
+		claims, err := s.parseUserToken(cookie.Value)
+		if err != nil || claims["purpose"] != oidcCookiePurpose {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		state, _ := claims["state"].(string)
+		nonce, _ := claims["nonce"].(string)
+		verifier, _ := claims["verifier"].(string)
+
+		if state == "" || query.Get("state") != state {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		identity, err := s.oidc.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+		if err != nil {
+			if errors.Is(err, oidc.ErrInvalidIDToken) {
+				invalidUserCredentialsError(w)
+				return
+			}
+			serverError(w, err)
+			return
+		}
+
+		user, err := s.userForIdentity(r.Context(), identity)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		token, err := s.generateUserToken(user)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		user.Token = token
+		writeJSON(w, http.StatusOK, M{"user": user})
+	}
+}
+
+// userForIdentity returns the user linked to identity and provisions a new one
+// on first login.
+func (s *Server) userForIdentity(ctx context.Context, identity *oidc.Claims) (*model.User, error) {
+	user, err := s.userService.UserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+
+	if !errors.Is(err, model.ErrNotFound) {
+		return nil, err
+	}
+
+	user = &model.User{
+		// Provisioned users sign in through the identity provider only. The
+		// hash cannot match any password until they set one.
+		PasswordHash: "!",
+	}
+
+	if identity.Email != "" && identity.EmailVerified {
+		now := time.Now()
+		user.Email = identity.Email
+		user.VerifiedAt = &now
+	}
+
+	base := oidcUsername(identity)
+	for i := 1; i <= oidcUsernameTrials; {
+		user.Username = base
+		if i > 1 {
+			user.Username = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		if model.IsReservedUsername(user.Username) {
+			i++
+			continue
+		}
+
+		link := &model.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+
+		err := s.userService.CreateUserWithIdentity(ctx, user, link)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, model.ErrDuplicateEmail):
+			// Never link to an existing account just because the email
+			// matches, that would let the identity provider take it over.
+			user.Email, user.VerifiedAt = "", nil
+		case errors.Is(err, model.ErrDuplicateUsername):
+			i++
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("could not find a free username for %q", base)
+}
+
+func oidcUsername(identity *oidc.Claims) string {
+	candidates := []string{identity.PreferredUsername}
+	if at := strings.Index(identity.Email, "@"); at > 0 {
+		candidates = append(candidates, identity.Email[:at])
+	}
+
+	for _, c := range candidates {
+		c = oidcUsernameDisallowed.ReplaceAllString(strings.ToLower(c), "")
+		if len(c) >= 2 {
+			return c
+		}
+	}
+
+	return "user"
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/oidc"
+)
+
+// stubIdP is a minimal OpenID provider that signs in a fixed user.
+type stubIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu             sync.Mutex
+	authorizations map[string]url.Values
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &stubIdP{key: key, authorizations: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{"keys": []M{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		idp.mu.Lock()
+		idp.authorizations["code"] = query
+		idp.mu.Unlock()
+
+		redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {"code"}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		idp.mu.Lock()
+		authorization, ok := idp.authorizations[r.PostForm.Get("code")]
+		delete(idp.authorizations, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != authorization.Get("code_challenge") {
+			writeJSON(w, http.StatusBadRequest, M{"error": "invalid_grant"})
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                idp.URL,
+			"sub":                "subject",
+			"aud":                authorization.Get("client_id"),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              authorization.Get("nonce"),
+			"email":              "Jane.Doe@example.com",
+			"email_verified":     true,
+			"preferred_username": "Jane.Doe",
+		})
+		idToken.Header["kid"] = "test"
+
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+func Test_oidcLogin(t *testing.T) {
+	idp := newStubIdP(t)
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://techblog.test/api/v1/auth/oidc/callback",
+	}, idp.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.oidc = provider
+
+	userStore.UserByIdentityFn = func(issuer, subject string) (*model.User, error) {
+		return nil, model.ErrNotFound
+	}
+
+	var identity *model.Identity
+	userStore.CreateUserWithIdentityFn = func(u *model.User, i *model.Identity) error {
+		identity = i
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusFound {
+		t.Fatalf("expected status code of 302, but got %d", code)
+	}
+
+	client := idp.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := struct {
+		User struct {
+			Username string `json:"username"`
+			Email    string `json:"email"`
+			Token    string `json:"token"`
+		} `json:"user"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusOK {
+		t.Fatalf("expected status code of 200, but got %d", code)
+	}
+
+	if gotResp.User.Username != "jane.doe" || gotResp.User.Email != "Jane.Doe@example.com" || gotResp.User.Token == "" {
+		t.Errorf("expected a provisioned user with a token, but got %v", gotResp.User)
+	}
+
+	if identity == nil || identity.Issuer != idp.URL || identity.Subject != "subject" {
+		t.Errorf("expected the identity to be linked, but got %v", identity)
+	}
+}
+
+func Test_oidcCallback_stateMismatch(t *testing.T) {
+	idp := newStubIdP(t)
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:      idp.URL,
+		ClientID:    "client",
+		RedirectURL: "http://techblog.test/api/v1/auth/oidc/callback",
+	}, idp.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := testServer()
+	srv.oidc = provider
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?code=code&state=forged", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnauthorized {
+		t.Errorf("expected status code of 401, but got %d", code)
+	}
+}
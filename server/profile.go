@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func profileResponse(user *model.User) M {
+	return M{
+		"username": user.Username,
+	}
+}
+
+func (s *Server) getProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+
+		user, err := s.userService.UserByUsername(r.Context(), username)
+		if err != nil {
+			if !errors.Is(err, model.ErrNotFound) {
+				serverError(w, err)
+				return
+			}
+
+			redirected, err := s.redirectPreviousUsername(w, r, username, func(current string) string {
+				return "/api/v1/profiles/" + url.PathEscape(current)
+			})
+			switch {
+			case err != nil:
+				serverError(w, err)
+			case !redirected:
+				err := ErrorM{"profile": []string{"requested profile not found"}}
+				notFoundError(w, err)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"profile": profileResponse(user)})
+	}
+}
+
+// redirectPreviousUsername permanently redirects to the location that to
+// builds from the current username if username used to belong to a user. It
+// reports whether it redirected.
+func (s *Server) redirectPreviousUsername(w http.ResponseWriter, r *http.Request, username string, to func(current string) string) (bool, error) {
+	user, err := s.userService.UserByPreviousUsername(r.Context(), username)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	http.Redirect(w, r, to(user.Username), http.StatusMovedPermanently)
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func Test_getProfile(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]*model.User{
+		"username": currentUser,
+		"renamed":  {ID: 2, Username: "renamed"},
+	}
+	userStore.UserByUsernameFn = func(username string) (*model.User, error) {
+		if u, ok := users[username]; ok {
+			return u, nil
+		}
+		return nil, model.ErrNotFound
+	}
+	userStore.UserByPreviousUsernameFn = func(username string) (*model.User, error) {
+		if username == "original" {
+			return users["renamed"], nil
+		}
+		return nil, model.ErrNotFound
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		location string
+		profile  M
+	}{
+		{"/api/v1/profiles/renamed", http.StatusOK, "", M{"username": "renamed"}},
+		{"/api/v1/profiles/original", http.StatusMovedPermanently, "/api/v1/profiles/renamed", nil},
+		{"/api/v1/profiles/unknown", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		if code := w.Code; code != tt.code {
+			t.Errorf("%s: expected status code of %d, but got %d", tt.path, tt.code, code)
+		}
+
+		if location := w.Header().Get("Location"); location != tt.location {
+			t.Errorf("%s: expected location %q, but got %q", tt.path, tt.location, location)
+		}
+
+		if tt.profile != nil {
+			gotResp := struct {
+				Profile M `json:"profile"`
+			}{}
+			if err := readJSON(w.Body, &gotResp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tt.profile, gotResp.Profile) {
+				t.Errorf("%s: expected profile %v, but got %v", tt.path, tt.profile, gotResp.Profile)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+// Limits of the routes that are expensive or attractive to abuse. Each route
+// has its own bucket per client.
+var (
+	signupRateLimit        = model.RateLimit{Limit: 5, Period: time.Hour}
+	loginRateLimit         = model.RateLimit{Limit: 20, Period: time.Minute}
+	passwordResetRateLimit = model.RateLimit{Limit: 5, Period: time.Hour}
+	emailRateLimit         = model.RateLimit{Limit: 5, Period: time.Hour}
+	writeRateLimit         = model.RateLimit{Limit: 30, Period: time.Hour}
+	tokenRateLimit         = model.RateLimit{Limit: 10, Period: time.Hour}
+)
+
+// rateLimit limits how often a client may call the route. Authenticated
+// clients are counted by user, everyone else by IP. Responses carry the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers, the
+// last in seconds until the quota is full again. Once it is used up, requests
+// are refused with 429 and a Retry-After header.
+//
+// If the limiter fails, requests are let through rather than taking the API
+// down with it.
+func (s *Server) rateLimit(name string, limit model.RateLimit) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.rateLimiter == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := s.rateLimiter.Allow(r.Context(), rateLimitKey(name, r), limit)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "error checking rate limit", "limit", name, "error", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+			if !result.Allowed {
+				s.metrics.rateLimited.WithLabelValues(name).Inc()
+				tooManyRequestsError(w, result.RetryAfter)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimitKey(name string, r *http.Request) string {
+	if user, err := userFromContext(r.Context()); err == nil && !user.IsAnonymous() {
+		return name + ":user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return name + ":ip:" + clientIP(r)
+}
+
+// memoryRateLimitSweepInterval is how often buckets that have refilled are
+// dropped, since they are equivalent to missing ones.
+const memoryRateLimitSweepInterval = time.Minute
+
+type memoryBucket struct {
+	bucket  model.RateLimitBucket
+	expires time.Time
+}
+
+// memoryRateLimiter keeps buckets in process memory. It is the default and is
+// only accurate with a single replica, since every replica counts on its own.
+type memoryRateLimiter struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{now: time.Now, buckets: map[string]memoryBucket{}}
+}
+
+func (l *memoryRateLimiter) Allow(_ context.Context, key string, limit model.RateLimit) (model.RateLimitResult, error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= memoryRateLimitSweepInterval {
+		for k, b := range l.buckets {
+			if now.After(b.expires) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, result := limit.Take(l.buckets[key].bucket, now)
+	l.buckets[key] = memoryBucket{bucket: bucket, expires: now.Add(result.Reset)}
+
+	return result, nil
+}
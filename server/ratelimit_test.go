@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func Test_rateLimit(t *testing.T) {
+	srv := testServer()
+	srv.rateLimiter = newMemoryRateLimiter()
+
+	signup := func(remoteAddr string) *httptest.ResponseRecorder {
+		// An empty body is refused before any service is called.
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader("{}"))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < signupRateLimit.Limit; i++ {
+		w := signup("192.0.2.1:1234")
+		if w.Code == http.StatusTooManyRequests {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "5" {
+			t.Errorf("expected X-RateLimit-Limit 5, but got %q", got)
+		}
+	}
+
+	w := signup("192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, but got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("expected X-RateLimit-Remaining 0, but got %q", got)
+	}
+	if got := w.Header().Get("Retry-After"); got != "720" {
+		t.Errorf("expected Retry-After 720, but got %q", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Reset"); got != "3600" {
+		t.Errorf("expected X-RateLimit-Reset 3600, but got %q", got)
+	}
+
+	if w := signup("192.0.2.2:1234"); w.Code == http.StatusTooManyRequests {
+		t.Error("expected another client to have its own quota")
+	}
+}
+
+func Test_rateLimit_trustedProxy(t *testing.T) {
+	srv := testServer()
+	srv.rateLimiter = newMemoryRateLimiter()
+	srv.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	signup := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader("{}"))
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		srv.handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < signupRateLimit.Limit; i++ {
+		signup("192.0.2.1")
+	}
+
+	if code := signup("192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the forwarded client to be limited, but got status code %d", code)
+	}
+
+	// Spoofing an entry left of the one added by the proxy does not help.
+	if code := signup("198.51.100.7, 192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a spoofed X-Forwarded-For to be ignored, but got status code %d", code)
+	}
+
+	if code := signup("192.0.2.2"); code == http.StatusTooManyRequests {
+		t.Error("expected another forwarded client to have its own quota")
+	}
+}
+
+func Test_rateLimitKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	if got := rateLimitKey("login", req); got != "login:ip:192.0.2.1" {
+		t.Errorf("expected an anonymous client to be keyed by IP, but got %q", got)
+	}
+
+	if got := rateLimitKey("login", setContextUser(req, &model.AnonymousUser)); got != "login:ip:192.0.2.1" {
+		t.Errorf("expected an anonymous user to be keyed by IP, but got %q", got)
+	}
+
+	if got := rateLimitKey("login", setContextUser(req, &model.User{ID: 42})); got != "login:user:42" {
+		t.Errorf("expected a user to be keyed by ID, but got %q", got)
+	}
+}
+
+func Test_forwardedClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor []string
+		want         string
+	}{
+		{"untrusted remote", "192.0.2.1", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"no header", "10.0.0.1", nil, "10.0.0.1"},
+		{"single proxy", "10.0.0.1", []string{"192.0.2.1"}, "192.0.2.1"},
+		{"proxy chain", "10.0.0.1", []string{"192.0.2.1, 10.0.0.2"}, "192.0.2.1"},
+		{"repeated headers", "10.0.0.1", []string{"192.0.2.1", "10.0.0.2"}, "192.0.2.1"},
+		{"spoofed entries", "10.0.0.1", []string{"198.51.100.7, 192.0.2.1"}, "192.0.2.1"},
+		{"only proxies", "10.0.0.1", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage", "10.0.0.1", []string{"unknown"}, "10.0.0.1"},
+		{"ipv6", "2001:db8::1", []string{"2001:db9::7"}, "2001:db9::7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedClientIP(tt.remote, tt.forwardedFor, trusted); got != tt.want {
+				t.Errorf("expected %s, but got %s", tt.want, got)
+			}
+		})
+	}
+}
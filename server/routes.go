@@ -1,31 +1,111 @@
 package server
 
-import "os"
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
 
 const (
 	MustAuth = true
 )
 
+// handler wraps the router in the middlewares that must also see requests no
+// route matches, which Router.Use only applies to matched routes.
+func (s *Server) handler() http.Handler {
+	h := s.metrics.instrument(s.router)
+	h = logRequests(h)
+	h = s.resolveClientIP(h)
+	h = requestID(h)
+	return traceRequests(h)
+}
+
 func (s *Server) routes() {
-	s.router.Use(Logger(os.Stdout))
+	s.router.Use(traceRoute)
+	s.router.Use(s.cors)
+
+	// Registered first so that OPTIONS never reaches routes that accept any
+	// method. A matcher func rather than Methods keeps mux from answering
+	// unknown paths with 405 because this route matches every path.
+	s.router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return r.Method == http.MethodOptions
+	}).Handler(s.preflight())
+
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeNotFoundError(w)
+	})
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methodNotAllowedError(w)
+	})
+
+	s.router.Handle("/metrics", s.metrics.handler()).Methods("GET")
+	s.router.Handle("/healthz", s.liveness()).Methods("GET")
+	s.router.Handle("/readyz", s.readiness()).Methods("GET")
+
 	apiRouter := s.router.PathPrefix("/api/v1").Subrouter()
 
 	noAuth := apiRouter.PathPrefix("").Subrouter()
 	{
-		noAuth.Handle("/health", healthCheck())
-		noAuth.Handle("/users", s.createUser()).Methods("POST")
-		noAuth.Handle("/users/login", s.loginUser()).Methods("POST")
+		noAuth.Handle("/health", s.healthCheck())
+		noAuth.Handle("/users", s.rateLimit("signup", signupRateLimit)(s.createUser())).Methods("POST")
+		noAuth.Handle("/users/login", s.rateLimit("login", loginRateLimit)(s.loginUser())).Methods("POST")
+		noAuth.Handle("/users/login/2fa", s.rateLimit("login-2fa", loginRateLimit)(s.loginTwoFactor())).Methods("POST")
+		noAuth.Handle("/users/verify", s.verifyEmail()).Methods("GET")
+		noAuth.Handle("/users/password/forgot", s.rateLimit("forgot-password", passwordResetRateLimit)(s.forgotPassword())).Methods("POST")
+		noAuth.Handle("/users/password/reset", s.rateLimit("reset-password", passwordResetRateLimit)(s.resetPassword())).Methods("POST")
+		noAuth.Handle("/auth/oidc/login", s.oidcLogin()).Methods("GET")
+		noAuth.Handle("/auth/oidc/callback", s.oidcCallback()).Methods("GET")
 	}
 
 	authApiRoutes := apiRouter.PathPrefix("").Subrouter()
 	authApiRoutes.Use(s.authenticate(MustAuth))
+
+	sessionRoutes := authApiRoutes.PathPrefix("").Subrouter()
+	sessionRoutes.Use(requireSession)
+	{
+		sessionRoutes.Handle("/user", s.updateUser()).Methods("PUT", "PATCH")
+		sessionRoutes.Handle("/user", s.deleteUser()).Methods("DELETE")
+		sessionRoutes.Handle("/user/export", s.exportUser()).Methods("GET")
+		sessionRoutes.Handle("/user/2fa/setup", s.setupTwoFactor()).Methods("POST")
+		sessionRoutes.Handle("/user/2fa/confirm", s.confirmTwoFactor()).Methods("POST")
+		sessionRoutes.Handle("/user/verification", s.rateLimit("verification-email", emailRateLimit)(s.resendVerificationEmail())).Methods("POST")
+		sessionRoutes.Handle("/user/tokens", s.listAccessTokens()).Methods("GET")
+		sessionRoutes.Handle("/user/tokens", s.rateLimit("create-token", tokenRateLimit)(s.createAccessToken())).Methods("POST")
+		sessionRoutes.Handle("/user/tokens/{id}", s.revokeAccessToken()).Methods("DELETE")
+	}
+
+	userReadRoutes := authApiRoutes.PathPrefix("").Subrouter()
+	userReadRoutes.Use(requireScope(model.ScopeUserRead))
+	{
+		userReadRoutes.Handle("/user", s.getCurrentUser()).Methods("GET")
+		userReadRoutes.Handle("/profiles/{username}", s.getProfile()).Methods("GET")
+	}
+
+	articlesReadRoutes := authApiRoutes.PathPrefix("").Subrouter()
+	articlesReadRoutes.Use(requireScope(model.ScopeArticlesRead))
+	{
+		articlesReadRoutes.Handle("/articles", s.listArticles()).Methods("GET")
+		articlesReadRoutes.Handle("/articles/trash", s.listTrashedArticles()).Methods("GET")
+		articlesReadRoutes.Handle("/articles/invitations", s.listCoAuthorInvitations()).Methods("GET")
+		articlesReadRoutes.Handle("/articles/{slug}", s.getArticle()).Methods("GET")
+		articlesReadRoutes.Handle("/articles/{slug}/related", s.listRelatedArticles()).Methods("GET")
+		articlesReadRoutes.Handle("/series", s.listSeries()).Methods("GET")
+		articlesReadRoutes.Handle("/series/{slug}", s.getSeries()).Methods("GET")
+	}
+
+	articlesWriteRoutes := authApiRoutes.PathPrefix("").Subrouter()
+	articlesWriteRoutes.Use(requireScope(model.ScopeArticlesWrite))
 	{
-		authApiRoutes.Handle("/user", s.getCurrentUser()).Methods("GET")
-		authApiRoutes.Handle("/user", s.updateUser()).Methods("PUT", "PATCH")
-		authApiRoutes.Handle("/articles", s.createArticle()).Methods("POST")
-		authApiRoutes.Handle("/articles", s.listArticles()).Methods("GET")
-		authApiRoutes.Handle("/articles/{slug}", s.getArticle()).Methods("GET")
-		authApiRoutes.Handle("/articles/{slug}", s.updateArticle()).Methods("PUT", "PATCH")
-		authApiRoutes.Handle("/articles/{slug}", s.deleteArticle()).Methods("DELETE")
+		articlesWriteRoutes.Handle("/articles", s.rateLimit("create-article", writeRateLimit)(s.createArticle())).Methods("POST")
+		articlesWriteRoutes.Handle("/articles/{slug}/restore", s.restoreArticle()).Methods("POST")
+		articlesWriteRoutes.Handle("/articles/{slug}/authors", s.rateLimit("invite-co-author", emailRateLimit)(s.inviteCoAuthor())).Methods("POST")
+		articlesWriteRoutes.Handle("/articles/{slug}/authors/accept", s.acceptCoAuthorInvitation()).Methods("POST")
+		articlesWriteRoutes.Handle("/articles/{slug}/authors/{username}", s.removeCoAuthor()).Methods("DELETE")
+		articlesWriteRoutes.Handle("/articles/{slug}", s.updateArticle()).Methods("PUT", "PATCH")
+		articlesWriteRoutes.Handle("/articles/{slug}", s.deleteArticle()).Methods("DELETE")
+		articlesWriteRoutes.Handle("/series", s.rateLimit("create-series", writeRateLimit)(s.createSeries())).Methods("POST")
+		articlesWriteRoutes.Handle("/series/{slug}", s.updateSeries()).Methods("PUT", "PATCH")
+		articlesWriteRoutes.Handle("/series/{slug}", s.deleteSeries()).Methods("DELETE")
 	}
 }
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func seriesError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateSlug):
+		err := ErrorM{"slug": []string{"this slug is already in use"}}
+		errorResponse(w, http.StatusConflict, err)
+	case errors.Is(err, model.ErrArticleInSeries):
+		err := ErrorM{"articles": []string{"an article already belongs to another series"}}
+		errorResponse(w, http.StatusConflict, err)
+	default:
+		serverError(w, err)
+	}
+}
+
+// seriesArticleIDs resolves the article slugs of a series. Users can only
+// collect articles they are an author of. It writes the error response and
+// returns false if a slug is refused.
+func (s *Server) seriesArticleIDs(ctx context.Context, w http.ResponseWriter, user *model.User, slugs []string) ([]uint, bool) {
+	ids := make([]uint, 0, len(slugs))
+	seen := map[string]bool{}
+
+	for _, slug := range slugs {
+		if seen[slug] {
+			validationError(w, ErrorM{"articles": []string{"must not contain an article twice"}})
+			return nil, false
+		}
+		seen[slug] = true
+
+		article, err := s.articleService.ArticleBySlug(ctx, slug)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				validationError(w, ErrorM{"articles": []string{"article " + slug + " not found"}})
+			default:
+				serverError(w, err)
+			}
+			return nil, false
+		}
+
+		if !article.IsAuthor(user.ID) {
+			err := ErrorM{"articles": []string{"you are not an author of " + slug}}
+			errorResponse(w, http.StatusForbidden, err)
+			return nil, false
+		}
+
+		ids = append(ids, article.ID)
+	}
+
+	return ids, true
+}
+
+// seriesForUpdate loads the series named in the route and checks that user
+// owns it. It writes the error response and returns nil otherwise.
+func (s *Server) seriesForUpdate(w http.ResponseWriter, r *http.Request, user *model.User) *model.Series {
+	series, err := s.seriesService.SeriesBySlug(r.Context(), mux.Vars(r)["slug"])
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			err := ErrorM{"series": []string{"requested series not found"}}
+			notFoundError(w, err)
+		default:
+			serverError(w, err)
+		}
+		return nil
+	}
+
+	if series.AuthorID != user.ID {
+		err := ErrorM{"series": []string{"forbidden request"}}
+		errorResponse(w, http.StatusForbidden, err)
+		return nil
+	}
+
+	return series
+}
+
+func (s *Server) createSeries() http.HandlerFunc {
+	type Input struct {
+		Series struct {
+			Title       string   `json:"title" validate:"required"`
+			Slug        string   `json:"slug" validate:"required"`
+			Description string   `json:"description"`
+			Articles    []string `json:"articles"`
+		} `json:"series"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.Series); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		articleIDs, ok := s.seriesArticleIDs(ctx, w, user, input.Series.Articles)
+		if !ok {
+			return
+		}
+
+		series := model.Series{
+			Title:       input.Series.Title,
+			Slug:        input.Series.Slug,
+			Description: input.Series.Description,
+			AuthorID:    user.ID,
+		}
+
+		if err := s.seriesService.CreateSeries(ctx, &series, articleIDs); err != nil {
+			seriesError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, M{"series": series})
+	}
+}
+
+func (s *Server) listSeries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := model.SeriesFilter{}
+
+		if v := r.URL.Query().Get("author"); v != "" {
+			filter.AuthorUsername = &v
+		}
+
+		series, err := s.seriesService.Series(r.Context(), filter)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"series": series})
+	}
+}
+
+func (s *Server) getSeries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		series, err := s.seriesService.SeriesBySlug(r.Context(), mux.Vars(r)["slug"])
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"series": []string{"requested series not found"}}
+				notFoundError(w, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"series": series})
+	}
+}
+
+func (s *Server) updateSeries() http.HandlerFunc {
+	type Input struct {
+		Series struct {
+			Title       *string   `json:"title,omitempty" validate:"omitempty,min=1"`
+			Description *string   `json:"description,omitempty"`
+			Articles    *[]string `json:"articles,omitempty"`
+		} `json:"series"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.Series); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		series := s.seriesForUpdate(w, r, user)
+		if series == nil {
+			return
+		}
+
+		patch := model.SeriesPatch{
+			Title:       input.Series.Title,
+			Description: input.Series.Description,
+		}
+
+		if v := input.Series.Articles; v != nil {
+			articleIDs, ok := s.seriesArticleIDs(ctx, w, user, *v)
+			if !ok {
+				return
+			}
+			patch.ArticleIDs = &articleIDs
+		}
+
+		if err := s.seriesService.UpdateSeries(ctx, series, patch); err != nil {
+			seriesError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"series": series})
+	}
+}
+
+func (s *Server) deleteSeries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userFromContext(r.Context())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		series := s.seriesForUpdate(w, r, user)
+		if series == nil {
+			return
+		}
+
+		if err := s.seriesService.DeleteSeries(r.Context(), series.ID); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusNoContent, nil)
+	}
+}
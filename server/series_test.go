@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+func Test_getArticle_series(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	seriesStore := &mock.SeriesService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+	srv.seriesService = seriesStore
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+
+	articleStore.ArticlesFn = func() ([]*model.Article, error) {
+		return []*model.Article{{ID: 2, Title: "part 2", Slug: "part-2"}}, nil
+	}
+
+	seriesStore.SeriesFn = func(f model.SeriesFilter) ([]*model.Series, error) {
+		if f.ArticleID == nil || *f.ArticleID != 2 {
+			t.Errorf("expected series to be looked up by article 2, but got %v", f.ArticleID)
+		}
+		return []*model.Series{{
+			Title: "deep dive",
+			Slug:  "deep-dive",
+			Articles: []*model.SeriesArticle{
+				{ArticleID: 1, Position: 1, Title: "part 1", Slug: "part-1"},
+				{ArticleID: 2, Position: 2, Title: "part 2", Slug: "part-2"},
+				{ArticleID: 3, Position: 3, Title: "part 3", Slug: "part-3"},
+			},
+		}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/articles/part-2", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := struct {
+		Article struct {
+			Series *model.SeriesNavigation `json:"series"`
+		} `json:"article"`
+	}{}
+	if err := readJSON(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	expected := &model.SeriesNavigation{
+		Title:    "deep dive",
+		Slug:     "deep-dive",
+		Position: 2,
+		Total:    3,
+		Previous: &model.SeriesArticle{Position: 1, Title: "part 1", Slug: "part-1"},
+		Next:     &model.SeriesArticle{Position: 3, Title: "part 3", Slug: "part-3"},
+	}
+
+	if !reflect.DeepEqual(expected, gotResp.Article.Series) {
+		t.Errorf("expected series navigation %+v, but got %+v", expected, gotResp.Article.Series)
+	}
+}
+
+func Test_createSeries_notAuthor(t *testing.T) {
+	articleStore := &mock.ArticleService{}
+	userStore := &mock.UserService{}
+	seriesStore := &mock.SeriesService{}
+	srv := testServer()
+	srv.articleService = articleStore
+	srv.userService = userStore
+	srv.seriesService = seriesStore
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+
+	articleStore.ArticleBySlugFn = func() (*model.Article, error) {
+		return &model.Article{ID: 1, Slug: "someone-elses", AuthorID: 2}, nil
+	}
+
+	seriesStore.CreateSeriesFn = func(s *model.Series, articleIDs []uint) error {
+		t.Error("expected series not to be created")
+		return nil
+	}
+
+	input := `{"series": {"title": "deep dive", "slug": "deep-dive", "articles": ["someone-elses"]}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/series", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusForbidden {
+		t.Errorf("expected status code of 403, but got %d", code)
+	}
+}
@@ -1,57 +1,220 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/msksgm/go-techblog-msksgm/mail"
 	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/oidc"
+	"github.com/msksgm/go-techblog-msksgm/password"
 	"github.com/msksgm/go-techblog-msksgm/postgres"
 )
 
+type Config struct {
+	// JWTSecret signs the tokens issued to users. It must be set.
+	JWTSecret []byte
+	Mailer    mail.Mailer
+	// PublicURL is the externally reachable base URL used in links sent to users.
+	PublicURL string
+	// RequireVerifiedEmail prevents users from publishing articles until they
+	// have verified their email address.
+	RequireVerifiedEmail bool
+	// PasswordPolicy decides which passwords users may choose. It defaults to
+	// password.DefaultPolicy.
+	PasswordPolicy *password.Policy
+	// OIDC enables signing in with an external identity provider when set.
+	OIDC *oidc.Provider
+	// ShutdownTimeout is how long Run waits for in-flight requests and
+	// background workers when shutting down. It defaults to 15 seconds.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long Run keeps serving after reporting itself as no
+	// longer ready, so that load balancers stop routing requests to it
+	// before it stops accepting connections. Zero shuts down right away.
+	DrainDelay time.Duration
+	// ReadTimeout, WriteTimeout and IdleTimeout configure the HTTP server.
+	// They default to 5 seconds.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// RateLimiter stores the rate limit buckets. It defaults to an in-memory
+	// limiter, which only counts the requests served by this process.
+	RateLimiter model.RateLimiter
+	// DisableRateLimit lets all requests through without counting them.
+	DisableRateLimit bool
+	// CORS lets browsers call the API from other origins, such as a
+	// separately hosted frontend.
+	CORS CORSConfig
+	// TrustedProxies are the proxies whose X-Forwarded-For header is used to
+	// find the client IP.
+	TrustedProxies []netip.Prefix
+}
+
+const (
+	defaultShutdownTimeout = 15 * time.Second
+	defaultHTTPTimeout     = 5 * time.Second
+)
+
 type Server struct {
-	server         *http.Server
-	router         *mux.Router
-	userService    model.UserService
-	articleService model.ArticleService
+	server               *http.Server
+	router               *mux.Router
+	jwtSecret            []byte
+	mailer               mail.Mailer
+	publicURL            string
+	requireVerifiedEmail bool
+	passwordPolicy       *password.Policy
+	oidc                 *oidc.Provider
+	userService          model.UserService
+	articleService       model.ArticleService
+	seriesService        model.SeriesService
+	tokenService         model.TokenService
+	loginAttemptService  model.LoginAttemptService
+	accessTokenService   model.PersonalAccessTokenService
+	rateLimiter          model.RateLimiter
+	trustedProxies       []netip.Prefix
+	corsPolicy           *corsPolicy
+
+	metrics *metrics
+	// dependencies are checked by the readiness probe, keyed by name.
+	dependencies map[string]dependency
+
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	// shuttingDown is set to 1 once the server starts shutting down, which
+	// fails the readiness probe.
+	shuttingDown int32
+	// workers tracks background goroutines that must finish before Run returns.
+	workers sync.WaitGroup
 }
 
-func NewServer(db *postgres.DB) *Server {
+func NewServer(db *postgres.DB, cfg Config) *Server {
 	s := Server{
 		server: &http.Server{
-			WriteTimeout: 5 * time.Second,
-			ReadTimeout:  5 * time.Second,
-			IdleTimeout:  5 * time.Second,
+			WriteTimeout: withDefault(cfg.WriteTimeout, defaultHTTPTimeout),
+			ReadTimeout:  withDefault(cfg.ReadTimeout, defaultHTTPTimeout),
+			IdleTimeout:  withDefault(cfg.IdleTimeout, defaultHTTPTimeout),
 		},
-		router: mux.NewRouter().StrictSlash(true),
+		router:               mux.NewRouter().StrictSlash(true),
+		jwtSecret:            cfg.JWTSecret,
+		mailer:               cfg.Mailer,
+		publicURL:            strings.TrimSuffix(cfg.PublicURL, "/"),
+		requireVerifiedEmail: cfg.RequireVerifiedEmail,
+		passwordPolicy:       cfg.PasswordPolicy,
+		oidc:                 cfg.OIDC,
+		rateLimiter:          cfg.RateLimiter,
+		trustedProxies:       cfg.TrustedProxies,
+		corsPolicy:           newCORSPolicy(cfg.CORS),
+		shutdownTimeout:      withDefault(cfg.ShutdownTimeout, defaultShutdownTimeout),
+		drainDelay:           cfg.DrainDelay,
+		metrics:              newMetrics(),
+	}
+
+	if s.passwordPolicy == nil {
+		s.passwordPolicy = &password.DefaultPolicy
+	}
+
+	switch {
+	case cfg.DisableRateLimit:
+		s.rateLimiter = nil
+	case s.rateLimiter == nil:
+		s.rateLimiter = newMemoryRateLimiter()
 	}
 
 	s.routes()
 
 	s.userService = postgres.NewUserService(db)
 	s.articleService = postgres.NewArticleService(db)
-	s.server.Handler = s.router
+	s.seriesService = postgres.NewSeriesService(db)
+	s.tokenService = postgres.NewTokenService(db)
+	s.loginAttemptService = postgres.NewLoginAttemptService(db)
+	s.accessTokenService = postgres.NewPersonalAccessTokenService(db)
+	s.metrics.registerDB(db.DB.DB)
+	s.dependencies = map[string]dependency{
+		"database": databaseDependency{db},
+	}
+	s.server.Handler = s.handler()
 
 	return &s
 }
 
-func (s *Server) Run(port string) error {
+// Run serves HTTP on port until ctx is done and then shuts down gracefully:
+// the server reports itself as no longer ready, keeps serving for the drain
+// delay, stops accepting connections, waits for in-flight requests and
+// background workers and returns. Workers are expected to stop on their own
+// when ctx is done.
+func (s *Server) Run(ctx context.Context, port string) error {
 	if !strings.HasPrefix(port, ":") {
 		port = ":" + port
 	}
 	s.server.Addr = port
-	log.Printf("server starting on %s", port)
-	return s.server.ListenAndServe()
-}
 
-func healthCheck() http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		resp := M{
-			"status":  "available",
-			"message": "health",
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", port)
+		errCh <- s.server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down server", "drain_delay", s.drainDelay)
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	if s.drainDelay > 0 {
+		select {
+		case <-time.After(s.drainDelay):
+		case err := <-errCh:
+			return err
 		}
-		writeJSON(rw, http.StatusOK, resp)
-	})
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return s.waitForWorkers(shutdownCtx)
+}
+
+func withDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
+
+func (s *Server) waitForWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("timed out waiting for background workers")
+	}
 }
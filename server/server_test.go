@@ -43,9 +43,34 @@ func Test_healthcheck(t *testing.T) {
 	}
 }
 
+func Test_healthcheck_shuttingDown(t *testing.T) {
+	srv := testServer()
+	srv.shuttingDown = 1
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code of 503, but got %d", code)
+	}
+
+	gotResp := M{}
+	if err := extractResponseBody(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := gotResp["status"]; status != "unavailable" {
+		t.Errorf("expected status unavailable, but got %v", status)
+	}
+}
+
 func testServer() *Server {
 	srv := &Server{
-		router: mux.NewRouter(),
+		router:    mux.NewRouter(),
+		jwtSecret: []byte("test-secret-test-secret-test-secret"),
+		metrics:   newMetrics(),
 	}
 	srv.routes()
 	return srv
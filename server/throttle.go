@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+// Failed logins are throttled with an exponential backoff once a username or
+// client IP exceeds its threshold within loginFailureWindow. The backoff is
+// capped at loginMaxLockout, which acts as a temporary lockout.
+const (
+	loginFailureWindow     = 15 * time.Minute
+	loginUsernameThreshold = 5
+	loginIPThreshold       = 20
+	loginBaseDelay         = time.Second
+	loginMaxLockout        = 15 * time.Minute
+)
+
+func loginBackoff(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := threshold; i < failures && delay < loginMaxLockout; i++ {
+		delay *= 2
+	}
+
+	if delay > loginMaxLockout {
+		delay = loginMaxLockout
+	}
+
+	return delay
+}
+
+// claimLoginAttempt records an attempt to log in as username as failed before
+// the credentials are checked, so that concurrent attempts count against each
+// other instead of all passing the throttle at once. If the client has to
+// wait, the claim is deleted again and the wait is returned instead.
+func (s *Server) claimLoginAttempt(ctx context.Context, username, ip string) (*model.LoginAttempt, time.Duration, error) {
+	attempt := &model.LoginAttempt{Username: username, IP: ip}
+	if err := s.loginAttemptService.RecordLoginAttempt(ctx, attempt); err != nil {
+		return nil, 0, err
+	}
+
+	retryAfter, err := s.loginRetryAfter(ctx, username, ip, attempt.ID)
+	if err != nil || retryAfter > 0 {
+		s.dropLoginAttempt(ctx, attempt)
+		return nil, retryAfter, err
+	}
+
+	return attempt, 0, nil
+}
+
+// succeedLoginAttempt marks a claimed attempt as a successful login.
+func (s *Server) succeedLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) {
+	if err := s.loginAttemptService.SucceedLoginAttempt(ctx, attempt.ID); err != nil {
+		slog.ErrorContext(ctx, "error recording login attempt", "error", err)
+	}
+}
+
+// dropLoginAttempt deletes a claimed attempt whose outcome is not recorded.
+func (s *Server) dropLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) {
+	if err := s.loginAttemptService.DeleteLoginAttempt(ctx, attempt.ID); err != nil {
+		slog.ErrorContext(ctx, "error deleting login attempt", "error", err)
+	}
+}
+
+// loginRetryAfter returns how long a client has to wait before it may try to
+// log in as username again, or zero if it may try right away. The attempt
+// with exceptID, the one being made, is not counted.
+func (s *Server) loginRetryAfter(ctx context.Context, username, ip string, exceptID uint) (time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-loginFailureWindow)
+
+	byUsername, err := s.loginAttemptService.LoginFailures(ctx, model.LoginAttemptFilter{
+		Username:         &username,
+		Since:            since,
+		SinceLastSuccess: true,
+		ExceptID:         exceptID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	byIP, err := s.loginAttemptService.LoginFailures(ctx, model.LoginAttemptFilter{
+		IP:       &ip,
+		Since:    since,
+		ExceptID: exceptID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	retryAfter := lockedFor(byUsername, loginUsernameThreshold, now)
+	if d := lockedFor(byIP, loginIPThreshold, now); d > retryAfter {
+		retryAfter = d
+	}
+
+	return retryAfter, nil
+}
+
+func lockedFor(failures model.LoginFailures, threshold int, now time.Time) time.Duration {
+	if failures.Count < threshold {
+		return 0
+	}
+
+	d := failures.Last.Add(loginBackoff(failures.Count, threshold)).Sub(now)
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/totp"
+)
+
+const (
+	totpIssuer        = "techblog"
+	recoveryCodeCount = 10
+)
+
+func (s *Server) setupTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if user.HasTwoFactor() {
+			err := ErrorM{"twoFactor": []string{"two-factor authentication is already enabled"}}
+			errorResponse(w, http.StatusConflict, err)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if err := s.userService.UpdateUser(ctx, user, model.UserPatch{TOTPSecret: &secret}); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		resp := M{
+			"secret":     secret,
+			"otpauthUri": totp.URI(totpIssuer, user.Username, secret),
+		}
+		writeJSON(w, http.StatusOK, M{"twoFactor": resp})
+	}
+}
+
+func (s *Server) confirmTwoFactor() http.HandlerFunc {
+	type Input struct {
+		TwoFactor struct {
+			Code string `json:"code" validate:"required"`
+		} `json:"twoFactor" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.TwoFactor); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if user.HasTwoFactor() {
+			err := ErrorM{"twoFactor": []string{"two-factor authentication is already enabled"}}
+			errorResponse(w, http.StatusConflict, err)
+			return
+		}
+
+		if user.TOTPSecret == "" {
+			err := ErrorM{"twoFactor": []string{"two-factor authentication has not been set up"}}
+			errorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		if !totp.Validate(user.TOTPSecret, input.TwoFactor.Code, time.Now()) {
+			err := ErrorM{"code": []string{"invalid authentication code"}}
+			errorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		codes, err := model.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		hashes := make([][]byte, 0, len(codes))
+		for _, code := range codes {
+			hashes = append(hashes, model.HashToken(code))
+		}
+
+		if err := s.userService.EnableTwoFactor(ctx, user.ID, hashes); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"twoFactor": M{"recoveryCodes": codes}})
+	}
+}
+
+func (s *Server) loginTwoFactor() http.HandlerFunc {
+	type Input struct {
+		Challenge struct {
+			Token string `json:"token" validate:"required"`
+			Code  string `json:"code" validate:"required"`
+		} `json:"challenge" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.Challenge); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		claims, err := s.parseUserToken(input.Challenge.Token)
+		if err != nil || claims["purpose"] != twoFactorChallengePurpose {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		username, ok := claims["username"].(string)
+		if !ok {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		ctx := r.Context()
+		ip := clientIP(r)
+
+		attempt, retryAfter, err := s.claimLoginAttempt(ctx, username, ip)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if retryAfter > 0 {
+			tooManyRequestsError(w, retryAfter)
+			return
+		}
+
+		user, err := s.userService.UserByUsername(ctx, username)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				invalidUserCredentialsError(w)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		ok, err = s.verifySecondFactor(r, user, input.Challenge.Code)
+		if err != nil {
+			s.dropLoginAttempt(ctx, attempt)
+			serverError(w, err)
+			return
+		}
+
+		if !ok {
+			s.metrics.loginsFailed.WithLabelValues("totp").Inc()
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		s.succeedLoginAttempt(ctx, attempt)
+
+		token, err := s.generateUserToken(user)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		user.Token = token
+		writeJSON(w, http.StatusOK, M{"user": user})
+	}
+}
+
+// verifySecondFactor accepts either a current TOTP code that was not used
+// before or one of the unused recovery codes of user.
+func (s *Server) verifySecondFactor(r *http.Request, user *model.User, code string) (bool, error) {
+	if !user.HasTwoFactor() {
+		return false, nil
+	}
+
+	if step, ok := totp.ValidateStep(user.TOTPSecret, code, time.Now()); ok {
+		err := s.userService.UseTOTPStep(r.Context(), user.ID, step)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, model.ErrNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	err := s.userService.UseRecoveryCode(r.Context(), user.ID, model.HashToken(code))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, model.ErrNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/mock"
+	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/totp"
+)
+
+func Test_confirmTwoFactor(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username", TOTPSecret: secret}
+	}
+
+	var hashes [][]byte
+	userStore.EnableTwoFactorFn = func(userID uint, h [][]byte) error {
+		hashes = h
+		return nil
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"twoFactor": {
+			"code": "` + code + `"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/2fa/confirm", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := struct {
+		TwoFactor struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		} `json:"twoFactor"`
+	}{}
+	if err := readJSON(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if n := len(gotResp.TwoFactor.RecoveryCodes); n != recoveryCodeCount || len(hashes) != n {
+		t.Errorf("expected %d recovery codes to be issued and stored, but got %d and %d", recoveryCodeCount, n, len(hashes))
+	}
+}
+
+func Test_loginUser_twoFactorChallenge(t *testing.T) {
+	userStore := &mock.UserService{}
+	loginAttemptStore := &mock.LoginAttemptService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.loginAttemptService = loginAttemptStore
+
+	loginAttemptStore.LoginFailuresFn = func(f model.LoginAttemptFilter) (model.LoginFailures, error) {
+		return model.LoginFailures{}, nil
+	}
+	attempts := map[uint]model.LoginAttempt{}
+	var lastID uint
+	loginAttemptStore.RecordLoginAttemptFn = func(a *model.LoginAttempt) error {
+		lastID++
+		a.ID = lastID
+		attempts[a.ID] = *a
+		return nil
+	}
+	loginAttemptStore.SucceedLoginAttemptFn = func(id uint) error {
+		a := attempts[id]
+		a.Success = true
+		attempts[id] = a
+		return nil
+	}
+	loginAttemptStore.DeleteLoginAttemptFn = func(id uint) error {
+		delete(attempts, id)
+		return nil
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabledAt := time.Now()
+	user := &model.User{ID: 1, Username: "username", TOTPSecret: secret, TOTPEnabledAt: &enabledAt}
+	userStore.AuthenticateFn = func() *model.User {
+		return user
+	}
+	userStore.GetCurrentUserFn = func() *model.User {
+		return user
+	}
+	userStore.UseTOTPStepFn = func(userID uint, step int64) error {
+		if user.TOTPLastStep != nil && *user.TOTPLastStep >= step {
+			return model.ErrNotFound
+		}
+		user.TOTPLastStep = &step
+		return nil
+	}
+
+	input := `{
+		"user": {
+			"username": "username",
+			"password": "password"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/login", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	loginResp := struct {
+		User      M `json:"user"`
+		Challenge struct {
+			Token string `json:"token"`
+		} `json:"challenge"`
+	}{}
+	if err := readJSON(w.Body, &loginResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if loginResp.User != nil || loginResp.Challenge.Token == "" {
+		t.Fatalf("expected a challenge instead of a user token, but got %v", loginResp)
+	}
+
+	if len(attempts) != 0 {
+		t.Errorf("expected the password step not to be recorded, but got %v", attempts)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/user", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", loginResp.Challenge.Token}, " "))
+	w = httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnauthorized {
+		t.Errorf("expected the challenge token to be rejected with 401, but got %d", code)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input = `{
+		"challenge": {
+			"token": "` + loginResp.Challenge.Token + `",
+			"code": "` + code + `"
+		}
+	}`
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/users/login/2fa", strings.NewReader(input))
+	w = httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	gotResp := M{}
+	if err := extractResponseUserBody(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if gotResp["token"] == nil {
+		t.Errorf("expected a user token, but got %v", gotResp)
+	}
+
+	if len(attempts) != 1 || !attempts[lastID].Success {
+		t.Errorf("expected one successful login attempt, but got %v", attempts)
+	}
+
+	// The code is still within its validity window but must not work twice.
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/users/login/2fa", strings.NewReader(input))
+	w = httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnauthorized {
+		t.Errorf("expected a replayed code to be rejected with 401, but got %d", code)
+	}
+}
+
+func Test_loginTwoFactor_recoveryCode(t *testing.T) {
+	userStore := &mock.UserService{}
+	loginAttemptStore := &mock.LoginAttemptService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.loginAttemptService = loginAttemptStore
+
+	loginAttemptStore.LoginFailuresFn = func(f model.LoginAttemptFilter) (model.LoginFailures, error) {
+		return model.LoginFailures{}, nil
+	}
+	loginAttemptStore.RecordLoginAttemptFn = func(a *model.LoginAttempt) error {
+		return nil
+	}
+	loginAttemptStore.SucceedLoginAttemptFn = func(id uint) error {
+		return nil
+	}
+
+	enabledAt := time.Now()
+	user := &model.User{ID: 1, Username: "username", TOTPSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", TOTPEnabledAt: &enabledAt}
+	userStore.GetCurrentUserFn = func() *model.User {
+		return user
+	}
+	userStore.UseRecoveryCodeFn = func(userID uint, hash []byte) error {
+		if string(hash) != string(model.HashToken("abcde-fghij")) {
+			return model.ErrNotFound
+		}
+		return nil
+	}
+
+	challenge, err := srv.generateChallengeToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := `{
+		"challenge": {
+			"token": "` + challenge + `",
+			"code": "ABCDE-FGHIJ"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/login/2fa", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+}
@@ -1,12 +1,18 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/msksgm/go-techblog-msksgm/mail"
 	"github.com/msksgm/go-techblog-msksgm/model"
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -24,30 +30,60 @@ func init() {
 	})
 }
 
+const (
+	passwordResetTokenTTL = 45 * time.Minute
+	verificationTokenTTL  = 3 * 24 * time.Hour
+)
+
 func userResponse(user *model.User, _token ...string) M {
 	if user == nil {
 		return nil
 	}
-	return M{
+	resp := M{
 		"username": user.Username,
 	}
+	if user.Email != "" {
+		resp["email"] = user.Email
+	}
+	return resp
 }
 
 func userTokenResponse(user *model.User, _token ...string) M {
 	if user == nil {
 		return nil
 	}
-	return M{
-		"username": user.Username,
-		"token":    user.Token,
+	resp := userResponse(user)
+	resp["token"] = user.Token
+	return resp
+}
+
+// checkPassword writes a validation error and returns false if the password
+// policy refuses password for the user with username.
+func (s *Server) checkPassword(w http.ResponseWriter, password, username string) bool {
+	if s.passwordPolicy == nil {
+		return true
+	}
+
+	violations, err := s.passwordPolicy.Violations(password, username)
+	if err != nil {
+		serverError(w, err)
+		return false
 	}
+
+	if len(violations) > 0 {
+		validationError(w, ErrorM{"password": violations})
+		return false
+	}
+
+	return true
 }
 
 func (s *Server) createUser() http.HandlerFunc {
 	type Input struct {
 		User struct {
 			Username string `json:"username" validate:"required,min=2"`
-			Password string `json:"password" validate:"required,min=8,max=72"`
+			Email    string `json:"email" validate:"required,email"`
+			Password string `json:"password" validate:"required,min=8,max=256"`
 		} `json:"user" validate:"required"`
 	}
 
@@ -64,25 +100,34 @@ func (s *Server) createUser() http.HandlerFunc {
 			return
 		}
 
+		if model.IsReservedUsername(input.User.Username) {
+			validationError(w, ErrorM{"username": []string{"is reserved"}})
+			return
+		}
+
+		if !s.checkPassword(w, input.User.Password, input.User.Username) {
+			return
+		}
+
 		user := model.User{
 			Username: input.User.Username,
+			Email:    input.User.Email,
 		}
 
-		err := user.SetPassword(input.User.Password)
-		if err != nil {
-			log.Fatalf("err is occured: %v", err)
+		if err := user.SetPassword(input.User.Password); err != nil {
+			serverError(w, err)
+			return
 		}
 
 		if err := s.userService.CreateUser(r.Context(), &user); err != nil {
-			switch {
-			case errors.Is(err, model.ErrDuplicateUsername):
-				err = ErrorM{"username": []string{"this username is already in use"}}
-				errorResponse(w, http.StatusConflict, err)
-			default:
-				serverError(w, err)
-			}
+			duplicateUserError(w, err)
 			return
 		}
+		s.metrics.usersRegistered.Inc()
+
+		if err := s.sendVerificationEmail(r.Context(), &user); err != nil {
+			slog.ErrorContext(r.Context(), "error sending verification email", "error", err)
+		}
 
 		writeJSON(w, http.StatusCreated, M{"user": user})
 	}
@@ -104,14 +149,53 @@ func (s *Server) loginUser() http.HandlerFunc {
 			return
 		}
 
-		user, err := s.userService.Authenticate(r.Context(), input.User.Username, input.User.Password)
+		ctx := r.Context()
+		ip := clientIP(r)
+
+		attempt, retryAfter, err := s.claimLoginAttempt(ctx, input.User.Username, ip)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if retryAfter > 0 {
+			tooManyRequestsError(w, retryAfter)
+			return
+		}
+
+		user, err := s.userService.Authenticate(ctx, input.User.Username, input.User.Password)
+		authenticated := err == nil && user != nil
 
-		if err != nil || user == nil {
+		// The claim already counts as a failure. A correct password only
+		// completes the login without two-factor authentication. Otherwise
+		// the second step records the outcome, and recording a success here
+		// would reset the failures that throttle guessing the second factor.
+		switch {
+		case !authenticated:
+		case user.HasTwoFactor():
+			s.dropLoginAttempt(ctx, attempt)
+		default:
+			s.succeedLoginAttempt(ctx, attempt)
+		}
+
+		if !authenticated {
+			s.metrics.loginsFailed.WithLabelValues("password").Inc()
 			invalidUserCredentialsError(w)
 			return
 		}
 
-		token, err := generateUserToken(user)
+		if user.HasTwoFactor() {
+			challenge, err := s.generateChallengeToken(user)
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, M{"challenge": M{"token": challenge, "type": "totp"}})
+			return
+		}
+
+		token, err := s.generateUserToken(user)
 		if err != nil {
 			serverError(w, err)
 			return
@@ -127,7 +211,8 @@ func (s *Server) getCurrentUser() http.HandlerFunc {
 		ctx := r.Context()
 		user, err := userFromContext(r.Context())
 		if err != nil {
-			log.Fatal(err)
+			serverError(w, err)
+			return
 		}
 		user.Token = userTokenFromContext(ctx)
 
@@ -138,8 +223,9 @@ func (s *Server) getCurrentUser() http.HandlerFunc {
 func (s *Server) updateUser() http.HandlerFunc {
 	type Input struct {
 		User struct {
-			Username *string `json:"username,omitempty"`
-			Password *string `json:"password,omitempty"`
+			Username *string `json:"username,omitempty" validate:"omitempty,min=2"`
+			Email    *string `json:"email,omitempty" validate:"omitempty,email"`
+			Password *string `json:"password,omitempty" validate:"omitempty,min=8,max=256"`
 		} `json:"user,omitempty" validate:"required"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -158,27 +244,359 @@ func (s *Server) updateUser() http.HandlerFunc {
 		ctx := r.Context()
 		user, err := userFromContext(r.Context())
 		if err != nil {
-			log.Fatal(err)
+			serverError(w, err)
+			return
+		}
+		if v := input.User.Username; v != nil && *v != user.Username {
+			if model.IsReservedUsername(*v) {
+				validationError(w, ErrorM{"username": []string{"is reserved"}})
+				return
+			}
+
+			if !user.CanChangeUsername(time.Now()) {
+				msg := fmt.Sprintf("can only be changed once every %d days", int(model.UsernameChangeCooldown.Hours()/24))
+				validationError(w, ErrorM{"username": []string{msg}})
+				return
+			}
 		}
+
 		patch := model.UserPatch{
 			Username: input.User.Username,
+			Email:    input.User.Email,
+		}
+
+		renamed := input.User.Username != nil && *input.User.Username != user.Username
+
+		emailChanged := input.User.Email != nil && *input.User.Email != user.Email
+		if emailChanged {
+			user.VerifiedAt = nil
 		}
 
 		if v := input.User.Password; v != nil {
-			err := user.SetPassword(*v)
-			if err != nil {
-				log.Fatalf("err is occured: %v", err)
+			username := user.Username
+			if input.User.Username != nil {
+				username = *input.User.Username
+			}
+
+			if !s.checkPassword(w, *v, username) {
+				return
+			}
+
+			if err := user.SetPassword(*v); err != nil {
+				serverError(w, err)
+				return
 			}
 		}
 
 		err = s.userService.UpdateUser(ctx, user, patch)
 		if err != nil {
-			serverError(w, err)
+			duplicateUserError(w, err)
 			return
 		}
 
-		user.Token = userTokenFromContext(ctx)
+		if emailChanged {
+			// Links mailed to the previous address must not verify the new one.
+			if err := s.tokenService.DeleteTokensForUser(ctx, model.ScopeVerification, user.ID); err != nil {
+				serverError(w, err)
+				return
+			}
+
+			if err := s.sendVerificationEmail(ctx, user); err != nil {
+				slog.ErrorContext(ctx, "error sending verification email", "error", err)
+			}
+		}
+
+		// Tokens name the user, so the old one stops working after a rename.
+		if renamed {
+			token, err := s.generateUserToken(user)
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			user.Token = token
+		} else {
+			user.Token = userTokenFromContext(ctx)
+		}
 
 		writeJSON(w, http.StatusOK, M{"user": user})
 	}
 }
+
+func (s *Server) exportUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		export, err := s.userService.ExportUser(ctx, user.ID)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		if err := writeUserExport(buf, export.User, export.Articles); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(export.User, time.Now())))
+		w.WriteHeader(http.StatusOK)
+		if _, err := buf.WriteTo(w); err != nil {
+			slog.ErrorContext(r.Context(), "error writing export", "error", err)
+		}
+	}
+}
+
+func (s *Server) deleteUser() http.HandlerFunc {
+	type Input struct {
+		User struct {
+			Password string `json:"password" validate:"required"`
+			Articles string `json:"articles" validate:"required,oneof=delete anonymize"`
+		} `json:"user" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.User); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if !user.VerifyPassword(input.User.Password) {
+			invalidUserCredentialsError(w)
+			return
+		}
+
+		policy := model.AuthoredContentPolicy(input.User.Articles)
+		if err := s.userService.DeleteUser(ctx, user.ID, policy); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+func (s *Server) forgotPassword() http.HandlerFunc {
+	type Input struct {
+		User struct {
+			Email string `json:"email" validate:"required,email"`
+		} `json:"user" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.User); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		// The response is the same whether or not the address is known, and
+		// it is sent before the address is even looked up, so that neither its
+		// content nor its timing tells who has an account.
+		ctx := context.WithoutCancel(r.Context())
+		s.background(func() {
+			s.sendPasswordResetEmail(ctx, input.User.Email)
+		})
+
+		resp := M{"message": "if an account with that email exists, a password reset token has been sent to it"}
+		writeJSON(w, http.StatusAccepted, resp)
+	}
+}
+
+func (s *Server) sendPasswordResetEmail(ctx context.Context, email string) {
+	user, err := s.userService.UserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, model.ErrNotFound) {
+			slog.ErrorContext(ctx, "error finding user for password reset", "error", err)
+		}
+		return
+	}
+
+	token, err := model.GenerateToken(user.ID, passwordResetTokenTTL, model.ScopePasswordReset)
+	if err != nil {
+		slog.ErrorContext(ctx, "error generating password reset token", "error", err)
+		return
+	}
+
+	if err := s.tokenService.CreateToken(ctx, token); err != nil {
+		slog.ErrorContext(ctx, "error creating password reset token", "error", err)
+		return
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the following token to reset your password:\n\n%s\n\nThe token expires at %s. If you did not ask for a password reset you can ignore this email.\n",
+			user.Username, token.Plaintext, token.Expiry.UTC().Format(time.RFC1123),
+		),
+	}
+
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "error sending password reset email", "error", err)
+	}
+}
+
+func (s *Server) resetPassword() http.HandlerFunc {
+	type Input struct {
+		User struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required,min=8,max=256"`
+		} `json:"user" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &Input{}
+
+		if err := readJSON(r.Body, &input); err != nil {
+			badRequestError(w)
+			return
+		}
+
+		if err := validate.Struct(input.User); err != nil {
+			validationError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := s.tokenService.TokenUser(ctx, model.ScopePasswordReset, input.User.Token)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				invalidPasswordResetTokenError(w)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		if !s.checkPassword(w, input.User.Password, user.Username) {
+			return
+		}
+
+		if err := user.SetPassword(input.User.Password); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		// Consuming the token and storing the password happen together, so a
+		// token cannot be used twice and a failed update leaves it usable.
+		// Every session of the user ends with it.
+		if _, err := s.tokenService.ResetPassword(ctx, input.User.Token, user.PasswordHash); err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				invalidPasswordResetTokenError(w)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"message": "your password was successfully reset"})
+	}
+}
+
+func (s *Server) sendVerificationEmail(ctx context.Context, user *model.User) error {
+	token, err := model.GenerateToken(user.ID, verificationTokenTTL, model.ScopeVerification)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenService.CreateToken(ctx, token); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/users/verify?token=%s", s.publicURL, url.QueryEscape(token.Plaintext))
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the following link:\n\n%s\n\nThe link expires at %s.\n",
+			user.Username, link, token.Expiry.UTC().Format(time.RFC1123),
+		),
+	}
+
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *Server) verifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plaintext := r.URL.Query().Get("token")
+		if plaintext == "" {
+			err := ErrorM{"token": []string{"this field is required"}}
+			errorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := s.tokenService.ConsumeToken(ctx, model.ScopeVerification, plaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNotFound):
+				err := ErrorM{"token": []string{"invalid or expired verification token"}}
+				errorResponse(w, http.StatusUnprocessableEntity, err)
+			default:
+				serverError(w, err)
+			}
+			return
+		}
+
+		now := time.Now()
+		if err := s.userService.UpdateUser(ctx, user, model.UserPatch{VerifiedAt: &now}); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, M{"message": "your email address was successfully verified"})
+	}
+}
+
+func (s *Server) resendVerificationEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := userFromContext(ctx)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if user.IsVerified() {
+			err := ErrorM{"email": []string{"your email address is already verified"}}
+			errorResponse(w, http.StatusConflict, err)
+			return
+		}
+
+		if err := s.sendVerificationEmail(ctx, user); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, M{"message": "a verification link has been sent to your email address"})
+	}
+}
@@ -1,27 +1,38 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/msksgm/go-techblog-msksgm/mail"
 	"github.com/msksgm/go-techblog-msksgm/mock"
 	"github.com/msksgm/go-techblog-msksgm/model"
+	"github.com/msksgm/go-techblog-msksgm/password"
 )
 
 func Test_createUser(t *testing.T) {
 	userStore := &mock.UserService{}
+	tokenStore := &mock.TokenService{}
+	mailer := &mock.Mailer{}
 	srv := testServer()
 	srv.userService = userStore
+	srv.tokenService = tokenStore
+	srv.mailer = mailer
 
 	input := `{
 		"user": {
 			"username": "username",
+			"email": "user@example.com",
 			"password": "password"
 		}
 	}`
@@ -35,6 +46,18 @@ func Test_createUser(t *testing.T) {
 		return nil
 	}
 
+	var token *model.Token
+	tokenStore.CreateTokenFn = func(tk *model.Token) error {
+		token = tk
+		return nil
+	}
+
+	var msg mail.Message
+	mailer.SendFn = func(m mail.Message) error {
+		msg = m
+		return nil
+	}
+
 	srv.router.ServeHTTP(w, req)
 	expectedResp := userResponse(&user)
 	gotResp := M{}
@@ -50,14 +73,87 @@ func Test_createUser(t *testing.T) {
 	if !reflect.DeepEqual(expectedResp, gotResp) {
 		t.Errorf("expected response %v, but got %v", expectedResp, gotResp)
 	}
+
+	if token == nil || token.Scope != model.ScopeVerification {
+		t.Fatalf("expected a verification token to be created, but got %v", token)
+	}
+
+	if msg.To != "user@example.com" || !strings.Contains(msg.Body, url.QueryEscape(token.Plaintext)) {
+		t.Errorf("expected a verification link to be mailed to user@example.com, but got %v", msg)
+	}
+}
+
+func Test_createUser_refusedPassword(t *testing.T) {
+	breached, err := password.LoadHashList(strings.NewReader("ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:42\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := testServer()
+	srv.passwordPolicy = &password.Policy{MinScore: 3, Breached: breached}
+
+	tests := []struct {
+		password string
+		expected []string
+	}{
+		{"password123", []string{"is too easy to guess, use a longer password or a few unrelated words"}},
+		{"username-vT9#kq2!Lm", []string{"must not contain your username"}},
+		{"correct horse battery staple", []string{"has appeared in a data breach, choose a different password"}},
+	}
+
+	for _, tt := range tests {
+		input := fmt.Sprintf(`{"user": {"username": "username", "email": "user@example.com", "password": %q}}`, tt.password)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(input))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		gotResp := struct {
+			Errors map[string][]string `json:"errors"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(&gotResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if code := w.Code; code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code of 422, but got %d", tt.password, code)
+		}
+
+		if !reflect.DeepEqual(gotResp.Errors["password"], tt.expected) {
+			t.Errorf("%s: expected password errors %v, but got %v", tt.password, tt.expected, gotResp.Errors)
+		}
+	}
 }
 
 func Test_loginUser(t *testing.T) {
 	userStore := &mock.UserService{}
+	loginAttemptStore := &mock.LoginAttemptService{}
 	srv := testServer()
 	srv.userService = userStore
+	srv.loginAttemptService = loginAttemptStore
+
+	loginAttemptStore.LoginFailuresFn = func(f model.LoginAttemptFilter) (model.LoginFailures, error) {
+		return model.LoginFailures{}, nil
+	}
+
+	var attempt model.LoginAttempt
+	loginAttemptStore.RecordLoginAttemptFn = func(a *model.LoginAttempt) error {
+		a.ID = 1
+		attempt = *a
+		return nil
+	}
+	loginAttemptStore.SucceedLoginAttemptFn = func(id uint) error {
+		if id == attempt.ID {
+			attempt.Success = true
+		}
+		return nil
+	}
 
 	userStore.AuthenticateFn = func() *model.User {
+		if attempt.ID == 0 {
+			t.Error("expected the attempt to be claimed before checking credentials")
+		}
 		user := &model.User{
 			Username: "username",
 		}
@@ -79,13 +175,132 @@ func Test_loginUser(t *testing.T) {
 	if code := w.Code; code != http.StatusOK {
 		t.Errorf("expected status code of 200, but got %d", code)
 	}
+
+	if !attempt.Success || attempt.Username != "username" {
+		t.Errorf("expected a successful login attempt to be recorded, but got %v", attempt)
+	}
+}
+
+func Test_loginUser_invalidCredentials(t *testing.T) {
+	userStore := &mock.UserService{}
+	loginAttemptStore := &mock.LoginAttemptService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.loginAttemptService = loginAttemptStore
+
+	attempts := map[uint]model.LoginAttempt{}
+	loginAttemptStore.RecordLoginAttemptFn = func(a *model.LoginAttempt) error {
+		a.ID = uint(len(attempts) + 1)
+		attempts[a.ID] = *a
+		return nil
+	}
+	loginAttemptStore.LoginFailuresFn = func(f model.LoginAttemptFilter) (model.LoginFailures, error) {
+		failures := model.LoginFailures{}
+		for id, a := range attempts {
+			if id != f.ExceptID && !a.Success {
+				failures.Count++
+				failures.Last = time.Now()
+			}
+		}
+		return failures, nil
+	}
+	loginAttemptStore.DeleteLoginAttemptFn = func(id uint) error {
+		delete(attempts, id)
+		return nil
+	}
+	userStore.AuthenticateFn = func() *model.User {
+		return nil
+	}
+
+	input := `{
+		"user": {
+			"username": "username",
+			"password": "wrong"
+		}
+	}`
+
+	// Every claim counts as a failure until the password proves otherwise,
+	// so the attempt after the threshold is refused without being checked.
+	for i := 0; i <= loginUsernameThreshold; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/login", strings.NewReader(input))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		expected := http.StatusUnauthorized
+		if i == loginUsernameThreshold {
+			expected = http.StatusTooManyRequests
+		}
+		if code := w.Code; code != expected {
+			t.Errorf("attempt %d: expected status code of %d, but got %d", i+1, expected, code)
+		}
+	}
+
+	if n := len(attempts); n != loginUsernameThreshold {
+		t.Errorf("expected %d failed attempts to be kept, but got %d", loginUsernameThreshold, n)
+	}
+}
+
+func Test_loginUser_tooManyAttempts(t *testing.T) {
+	userStore := &mock.UserService{}
+	loginAttemptStore := &mock.LoginAttemptService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.loginAttemptService = loginAttemptStore
+
+	loginAttemptStore.RecordLoginAttemptFn = func(a *model.LoginAttempt) error {
+		a.ID = 1
+		return nil
+	}
+	var deleted uint
+	loginAttemptStore.DeleteLoginAttemptFn = func(id uint) error {
+		deleted = id
+		return nil
+	}
+	loginAttemptStore.LoginFailuresFn = func(f model.LoginAttemptFilter) (model.LoginFailures, error) {
+		if f.ExceptID != 1 {
+			t.Errorf("expected the claimed attempt not to be counted, but got filter %v", f)
+		}
+		if f.Username != nil {
+			return model.LoginFailures{Count: loginUsernameThreshold + 2, Last: time.Now().Add(-time.Second / 2)}, nil
+		}
+		return model.LoginFailures{}, nil
+	}
+	userStore.AuthenticateFn = func() *model.User {
+		t.Error("expected credentials not to be checked")
+		return nil
+	}
+
+	input := `{
+		"user": {
+			"username": "username",
+			"password": "password"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/login", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusTooManyRequests {
+		t.Errorf("expected status code of 429, but got %d", code)
+	}
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "4" {
+		t.Errorf("expected Retry-After of 4, but got %q", retryAfter)
+	}
+
+	if deleted != 1 {
+		t.Errorf("expected the refused attempt to be deleted, but got %d", deleted)
+	}
 }
 
 func Test_getCurrentUser(t *testing.T) {
 	userStore := &mock.UserService{}
 	srv := testServer()
 	srv.userService = userStore
-	token, err := generateUserToken(
+	token, err := srv.generateUserToken(
 		&model.User{
 			ID:       1,
 			Username: "username",
@@ -123,11 +338,178 @@ func Test_getCurrentUser(t *testing.T) {
 	}
 }
 
+func Test_updateUser_usernameRules(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	changedAt := time.Now().Add(-24 * time.Hour)
+	currentUser := &model.User{ID: 1, Username: "username", UsernameChangedAt: &changedAt}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+
+	tests := []struct {
+		username string
+		expected []string
+	}{
+		{"Admin", []string{"is reserved"}},
+		{"renamed", []string{"can only be changed once every 30 days"}},
+	}
+
+	for _, tt := range tests {
+		input := fmt.Sprintf(`{"user": {"username": %q}}`, tt.username)
+
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/user", strings.NewReader(input))
+		req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+		w := httptest.NewRecorder()
+
+		srv.router.ServeHTTP(w, req)
+
+		gotResp := struct {
+			Errors map[string][]string `json:"errors"`
+		}{}
+		if err := json.NewDecoder(w.Body).Decode(&gotResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if code := w.Code; code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code of 422, but got %d", tt.username, code)
+		}
+
+		if !reflect.DeepEqual(gotResp.Errors["username"], tt.expected) {
+			t.Errorf("%s: expected username errors %v, but got %v", tt.username, tt.expected, gotResp.Errors)
+		}
+	}
+}
+
+func Test_updateUser_rename(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+	userStore.UpdateUserFn = func(u *model.User, patch model.UserPatch) error {
+		u.Username = *patch.Username
+		return nil
+	}
+
+	input := `{"user": {"username": "renamed"}}`
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/user", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Fatalf("expected status code of 200, but got %d", code)
+	}
+
+	gotResp := M{}
+	if err := extractResponseUserBody(w.Body, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, _ := gotResp["token"].(string)
+	if newToken == token {
+		t.Fatal("expected a new token after the rename")
+	}
+
+	claims, err := srv.parseUserToken(newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims["username"] != "renamed" {
+		t.Errorf("expected the token to name renamed, but got %v", claims["username"])
+	}
+}
+
+func Test_updateUser_emailChange(t *testing.T) {
+	userStore := &mock.UserService{}
+	tokenStore := &mock.TokenService{}
+	mailer := &mock.Mailer{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.tokenService = tokenStore
+	srv.mailer = mailer
+
+	verifiedAt := time.Now()
+	currentUser := &model.User{ID: 1, Username: "username", Email: "old@example.com", VerifiedAt: &verifiedAt}
+	token, err := srv.generateUserToken(currentUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+	userStore.UpdateUserFn = func(u *model.User, patch model.UserPatch) error {
+		u.Email = *patch.Email
+		return nil
+	}
+
+	calls := []string{}
+	tokenStore.DeleteTokensForUserFn = func(scope string, userID uint) error {
+		calls = append(calls, "delete "+scope)
+		return nil
+	}
+	tokenStore.CreateTokenFn = func(tk *model.Token) error {
+		calls = append(calls, "create "+tk.Scope)
+		return nil
+	}
+
+	var msg mail.Message
+	mailer.SendFn = func(m mail.Message) error {
+		msg = m
+		return nil
+	}
+
+	input := `{"user": {"email": "new@example.com"}}`
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/user", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Fatalf("expected status code of 200, but got %d", code)
+	}
+
+	expectedCalls := []string{"delete " + model.ScopeVerification, "create " + model.ScopeVerification}
+	if !reflect.DeepEqual(expectedCalls, calls) {
+		t.Errorf("expected the old verification tokens to be deleted first, but got %v", calls)
+	}
+
+	if msg.To != "new@example.com" {
+		t.Errorf("expected a verification email to new@example.com, but got %v", msg)
+	}
+
+	if currentUser.IsVerified() {
+		t.Error("expected the new email to be unverified")
+	}
+}
+
 // func Test_updateUser(t *testing.T) {
 // 	userStore := &mock.UserService{}
 // 	srv := testServer()
 // 	srv.userService = userStore
-// 	token, err := generateUserToken(
+// 	token, err := srv.generateUserToken(
 // 		&model.User{
 // 			ID:       1,
 // 			Username: "username",
@@ -176,6 +558,371 @@ func Test_getCurrentUser(t *testing.T) {
 // 	}
 // }
 
+func Test_deleteUser(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentUser := &model.User{ID: 1, Username: "username"}
+	if err := currentUser.SetPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	userStore.GetCurrentUserFn = func() *model.User {
+		return currentUser
+	}
+
+	var gotPolicy model.AuthoredContentPolicy
+	userStore.DeleteUserFn = func(id uint, policy model.AuthoredContentPolicy) error {
+		gotPolicy = policy
+		return nil
+	}
+
+	input := `{
+		"user": {
+			"password": "password",
+			"articles": "anonymize"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/user", strings.NewReader(input))
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusNoContent {
+		t.Errorf("expected status code of 204, but got %d", code)
+	}
+
+	if gotPolicy != model.AnonymizeAuthoredContent {
+		t.Errorf("expected policy %q, but got %q", model.AnonymizeAuthoredContent, gotPolicy)
+	}
+}
+
+func Test_exportUser(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+	token, err := srv.generateUserToken(
+		&model.User{
+			ID:       1,
+			Username: "username",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username"}
+	}
+	userStore.ExportUserFn = func(userID uint) (*model.UserExport, error) {
+		deletedAt := time.Now()
+		return &model.UserExport{
+			User: &model.User{ID: userID, Username: "username"},
+			Articles: []*model.Article{
+				{ID: 1, Title: "title", Body: "body", Slug: "slug"},
+				{ID: 2, Title: "trashed", Body: "body", Slug: "slug", DeletedAt: &deletedAt},
+				{ID: 3, Title: "escape", Body: "body", Slug: "../../x"},
+				{ID: 4, Title: "windows", Body: "body", Slug: `..\..\y`},
+			},
+		}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/user/export", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Fatalf("expected status code of 200, but got %d", code)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+
+	// Trashed articles may share a slug with a live one, and slugs must not
+	// lead out of the articles directory.
+	expectedNames := []string{"profile.json", "articles/1-slug.md", "articles/2-slug.md", "articles/3-x.md", "articles/4-y.md"}
+	if !reflect.DeepEqual(expectedNames, names) {
+		t.Errorf("expected files %v, but got %v", expectedNames, names)
+	}
+}
+
+func Test_forgotPassword(t *testing.T) {
+	userStore := &mock.UserService{}
+	tokenStore := &mock.TokenService{}
+	mailer := &mock.Mailer{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.tokenService = tokenStore
+	srv.mailer = mailer
+
+	userStore.UserByEmailFn = func(email string) (*model.User, error) {
+		return &model.User{ID: 1, Username: "username", Email: email}, nil
+	}
+
+	var token *model.Token
+	tokenStore.CreateTokenFn = func(tk *model.Token) error {
+		token = tk
+		return nil
+	}
+
+	var msg mail.Message
+	mailer.SendFn = func(m mail.Message) error {
+		msg = m
+		return nil
+	}
+
+	input := `{
+		"user": {
+			"email": "user@example.com"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/password/forgot", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+	srv.workers.Wait()
+
+	if code := w.Code; code != http.StatusAccepted {
+		t.Errorf("expected status code of 202, but got %d", code)
+	}
+
+	if token == nil || token.Scope != model.ScopePasswordReset {
+		t.Fatalf("expected a password reset token to be created, but got %v", token)
+	}
+
+	if msg.To != "user@example.com" || !strings.Contains(msg.Body, token.Plaintext) {
+		t.Errorf("expected the token to be mailed to user@example.com, but got %v", msg)
+	}
+}
+
+func Test_forgotPassword_unknownEmail(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	userStore.UserByEmailFn = func(email string) (*model.User, error) {
+		return nil, model.ErrNotFound
+	}
+
+	input := `{
+		"user": {
+			"email": "unknown@example.com"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/password/forgot", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+	srv.workers.Wait()
+
+	if code := w.Code; code != http.StatusAccepted {
+		t.Errorf("expected status code of 202, but got %d", code)
+	}
+}
+
+func Test_resetPassword(t *testing.T) {
+	tokenStore := &mock.TokenService{}
+	srv := testServer()
+	srv.tokenService = tokenStore
+
+	user := &model.User{ID: 1, Username: "username"}
+	tokenStore.TokenUserFn = func(scope, plaintext string) (*model.User, error) {
+		if scope != model.ScopePasswordReset || plaintext != "token" {
+			return nil, model.ErrNotFound
+		}
+		return &model.User{ID: user.ID, Username: user.Username}, nil
+	}
+	tokenStore.ResetPasswordFn = func(plaintext, passwordHash string) (*model.User, error) {
+		if plaintext != "token" {
+			return nil, model.ErrNotFound
+		}
+		user.PasswordHash = passwordHash
+		user.SessionVersion++
+		return user, nil
+	}
+
+	input := `{
+		"user": {
+			"token": "token",
+			"password": "new_password"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/password/reset", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if !user.VerifyPassword("new_password") {
+		t.Error("expected password to be updated")
+	}
+}
+
+func Test_resetPassword_refusedPassword(t *testing.T) {
+	tokenStore := &mock.TokenService{}
+	srv := testServer()
+	srv.tokenService = tokenStore
+	srv.passwordPolicy = &password.Policy{MinScore: 3}
+
+	tokenStore.TokenUserFn = func(scope, plaintext string) (*model.User, error) {
+		return &model.User{ID: 1, Username: "username"}, nil
+	}
+	tokenStore.ResetPasswordFn = func(plaintext, passwordHash string) (*model.User, error) {
+		t.Error("expected the token not to be consumed")
+		return nil, model.ErrNotFound
+	}
+
+	input := `{
+		"user": {
+			"token": "token",
+			"password": "password123"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/password/reset", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code of 422, but got %d", code)
+	}
+}
+
+func Test_resetPassword_tokenAlreadyUsed(t *testing.T) {
+	tokenStore := &mock.TokenService{}
+	srv := testServer()
+	srv.tokenService = tokenStore
+
+	tokenStore.TokenUserFn = func(scope, plaintext string) (*model.User, error) {
+		return &model.User{ID: 1, Username: "username"}, nil
+	}
+	// The token was consumed by a concurrent reset after it was looked up.
+	tokenStore.ResetPasswordFn = func(plaintext, passwordHash string) (*model.User, error) {
+		return nil, model.ErrNotFound
+	}
+
+	input := `{
+		"user": {
+			"token": "token",
+			"password": "new_password"
+		}
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/password/reset", strings.NewReader(input))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code of 422, but got %d", code)
+	}
+}
+
+func Test_staleSession(t *testing.T) {
+	userStore := &mock.UserService{}
+	srv := testServer()
+	srv.userService = userStore
+
+	token, err := srv.generateUserToken(&model.User{ID: 1, Username: "username"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The password was reset after the token was issued.
+	userStore.GetCurrentUserFn = func() *model.User {
+		return &model.User{ID: 1, Username: "username", SessionVersion: 1}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/user", nil)
+	req.Header.Add("Authorization", strings.Join([]string{"Bearer", token}, " "))
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnauthorized {
+		t.Errorf("expected status code of 401, but got %d", code)
+	}
+}
+
+func Test_verifyEmail(t *testing.T) {
+	userStore := &mock.UserService{}
+	tokenStore := &mock.TokenService{}
+	srv := testServer()
+	srv.userService = userStore
+	srv.tokenService = tokenStore
+
+	user := &model.User{ID: 1, Username: "username"}
+	tokenStore.ConsumeTokenFn = func(scope, plaintext string) (*model.User, error) {
+		if scope != model.ScopeVerification || plaintext != "token" {
+			return nil, model.ErrNotFound
+		}
+		return user, nil
+	}
+	userStore.UpdateUserFn = func(u *model.User, up model.UserPatch) error {
+		u.VerifiedAt = up.VerifiedAt
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/verify?token=token", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusOK {
+		t.Errorf("expected status code of 200, but got %d", code)
+	}
+
+	if !user.IsVerified() {
+		t.Error("expected user to be verified")
+	}
+}
+
+func Test_verifyEmail_invalidToken(t *testing.T) {
+	tokenStore := &mock.TokenService{}
+	srv := testServer()
+	srv.tokenService = tokenStore
+
+	tokenStore.ConsumeTokenFn = func(scope, plaintext string) (*model.User, error) {
+		return nil, model.ErrNotFound
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/verify?token=invalid", nil)
+	w := httptest.NewRecorder()
+
+	srv.router.ServeHTTP(w, req)
+
+	if code := w.Code; code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code of 422, but got %d", code)
+	}
+}
+
 func extractResponseUserBody(body io.Reader, v interface{}) error {
 	mm := M{}
 	_ = readJSON(body, &mm)
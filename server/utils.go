@@ -3,8 +3,11 @@ package server
 import (
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/msksgm/go-techblog-msksgm/model"
@@ -25,7 +28,7 @@ func writeJSON(w http.ResponseWriter, code int, data interface{}) {
 	_, err = w.Write(jsonBytes)
 
 	if err != nil {
-		log.Println(err)
+		slog.Error("error writing response", "error", err, "request_id", w.Header().Get(requestIDHeader))
 	}
 }
 
@@ -33,15 +36,51 @@ func readJSON(body io.Reader, input interface{}) error {
 	return json.NewDecoder(body).Decode(input)
 }
 
-var hmacSampleSecret = []byte("sample-secret")
+// clientIP returns the address of the client, as resolved by the
+// resolveClientIP middleware when it ran.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ifMatch reports whether the If-Match precondition of r holds for etag.
+// A request without If-Match is always allowed through.
+func ifMatch(r *http.Request, etag string) bool {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+	}
 
-func generateUserToken(user *model.User) (string, error) {
+	return false
+}
+
+func (s *Server) generateUserToken(user *model.User) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":       user.ID,
 		"username": user.Username,
+		"session":  user.SessionVersion,
 	})
 
-	tokenString, err := token.SignedString(hmacSampleSecret)
+	tokenString, err := token.SignedString(s.jwtSecret)
 	if err != nil {
 		return "", err
 	}
@@ -49,13 +88,31 @@ func generateUserToken(user *model.User) (string, error) {
 	return tokenString, nil
 }
 
-func parseUserToken(tokenStr string) (userClaims M, err error) {
+const (
+	twoFactorChallengePurpose = "2fa-challenge"
+	twoFactorChallengeTTL     = 5 * time.Minute
+)
+
+// generateChallengeToken issues a short-lived token proving that the password
+// of user was verified. It can only be exchanged for a user token together
+// with a valid second factor.
+func (s *Server) generateChallengeToken(user *model.User) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": user.Username,
+		"purpose":  twoFactorChallengePurpose,
+		"exp":      time.Now().Add(twoFactorChallengeTTL).Unix(),
+	})
+
+	return token.SignedString(s.jwtSecret)
+}
+
+func (s *Server) parseUserToken(tokenStr string) (userClaims M, err error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, model.ErrUnAuthorized
 		}
 
-		return hmacSampleSecret, nil
+		return s.jwtSecret, nil
 	})
 	if err != nil {
 		return nil, err
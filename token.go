@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var errTokenUsage = usageError(`usage: techblog token [flags] <action>
+
+actions:
+  revoke <id>
+  revoke -user <username>   revoke every active token of the user`)
+
+// token manages personal access tokens from the command line.
+func token(args []string) error {
+	return runAdmin(args, map[string]adminCommand{
+		"revoke": revokeTokens,
+	}, errTokenUsage)
+}
+
+func revokeTokens(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("token revoke", flag.ContinueOnError)
+	username := fs.String("user", "", "revoke all active tokens of this user")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		if fs.NArg() != 1 {
+			return errTokenUsage
+		}
+
+		id, err := strconv.ParseUint(fs.Arg(0), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid token id %q", fs.Arg(0))
+		}
+
+		err = env.pats.RevokePersonalAccessToken(ctx, uint(id))
+		if errors.Is(err, model.ErrNotFound) {
+			return fmt.Errorf("no active token with id %d", id)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("revoked token %d\n", id)
+		return nil
+	}
+
+	if fs.NArg() != 0 {
+		return errTokenUsage
+	}
+
+	u, err := findUser(ctx, env, *username)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := env.pats.PersonalAccessTokens(ctx, model.PersonalAccessTokenFilter{UserID: &u.ID})
+	if err != nil {
+		return err
+	}
+
+	revoked := 0
+	for _, t := range tokens {
+		if t.RevokedAt != nil {
+			continue
+		}
+		if err := env.pats.RevokePersonalAccessToken(ctx, t.ID); err != nil {
+			return err
+		}
+		revoked++
+	}
+
+	fmt.Printf("revoked %d tokens of %s\n", revoked, u.Username)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 Appendix B, truncated to 6 digits.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Code at %d: expected %s, but got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code, err := Code(secret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !Validate(secret, code, now) {
+		t.Error("expected code of the previous step to be accepted")
+	}
+
+	if Validate(secret, code, now.Add(2*Period)) {
+		t.Error("expected code from three steps ago to be rejected")
+	}
+}
+
+func TestValidateStep(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(59, 0)
+	code, err := Code(secret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if step, ok := ValidateStep(secret, code, now); !ok || step != 0 {
+		t.Errorf("expected the code to be accepted for step 0, but got %d, %v", step, ok)
+	}
+
+	if step, ok := ValidateStep(secret, code, now.Add(Period)); ok {
+		t.Errorf("expected the code to be rejected two steps later, but got step %d", step)
+	}
+}
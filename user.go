@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/msksgm/go-techblog-msksgm/model"
+)
+
+var errUserUsage = usageError(`usage: techblog user [flags] <action>
+
+actions:
+  create -username <name> -email <email> [-role user|admin] [-verified] [-password-stdin]
+  list [-role user|admin] [-limit n] [-offset n]
+  set-role <username> user|admin
+  reset-password [-password-stdin] <username>
+
+Without -password-stdin a random password is generated and printed.`)
+
+// user manages accounts from the command line.
+func user(args []string) error {
+	return runAdmin(args, map[string]adminCommand{
+		"create":         createUser,
+		"list":           listUsers,
+		"set-role":       setUserRole,
+		"reset-password": resetUserPassword,
+	}, errUserUsage)
+}
+
+func createUser(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	username := fs.String("username", "", "username of the new user")
+	email := fs.String("email", "", "email address of the new user")
+	role := fs.String("role", string(model.RoleUser), "role of the new user: user or admin")
+	verified := fs.Bool("verified", false, "mark the email address as verified")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+
+	if _, err := parseActionFlags(fs, args, 0, errUserUsage); err != nil {
+		return err
+	}
+
+	var errs []error
+	if len(*username) < 2 {
+		errs = append(errs, errors.New("-username must be at least 2 characters"))
+	} else if model.IsReservedUsername(*username) {
+		errs = append(errs, fmt.Errorf("username %q is reserved", *username))
+	}
+	if _, err := mail.ParseAddress(*email); err != nil || *email == "" {
+		errs = append(errs, errors.New("-email must be an email address"))
+	}
+	r, err := model.ParseRole(*role)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	password, generated, err := newPassword(env, os.Stdin, *passwordStdin, *username)
+	if err != nil {
+		return err
+	}
+
+	u := &model.User{Username: *username, Email: *email, Role: r}
+	if *verified {
+		now := time.Now()
+		u.VerifiedAt = &now
+	}
+	if err := u.SetPassword(password); err != nil {
+		return err
+	}
+
+	if err := env.users.CreateUser(ctx, u); err != nil {
+		return err
+	}
+
+	fmt.Printf("created %s %s (id %d)\n", u.Role, u.Username, u.ID)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+
+	return nil
+}
+
+func listUsers(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	role := fs.String("role", "", "only list users with this role")
+	limit := fs.Int("limit", 50, "maximum number of users to list")
+	offset := fs.Int("offset", 0, "number of users to skip")
+
+	if _, err := parseActionFlags(fs, args, 0, errUserUsage); err != nil {
+		return err
+	}
+
+	filter := model.UserFilter{Limit: *limit, Offset: *offset}
+	if *role != "" {
+		r, err := model.ParseRole(*role)
+		if err != nil {
+			return err
+		}
+		filter.Role = &r
+	}
+
+	users, err := env.users.Users(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tROLE\tVERIFIED\t2FA\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			u.ID, u.Username, u.Email, u.Role, yesNo(u.IsVerified()), yesNo(u.HasTwoFactor()), u.CreatedAt.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+func setUserRole(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("user set-role", flag.ContinueOnError)
+
+	args, err := parseActionFlags(fs, args, 2, errUserUsage)
+	if err != nil {
+		return err
+	}
+
+	role, err := model.ParseRole(args[1])
+	if err != nil {
+		return err
+	}
+
+	u, err := findUser(ctx, env, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := env.users.UpdateUser(ctx, u, model.UserPatch{Role: &role}); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", u.Username, u.Role)
+
+	return nil
+}
+
+func resetUserPassword(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+
+	args, err := parseActionFlags(fs, args, 1, errUserUsage)
+	if err != nil {
+		return err
+	}
+
+	u, err := findUser(ctx, env, args[0])
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := newPassword(env, os.Stdin, *passwordStdin, u.Username)
+	if err != nil {
+		return err
+	}
+
+	hash, err := model.DefaultPasswordHashing.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// Everyone signed in as the user is signed out, since the account may
+	// have been compromised.
+	if err := env.users.SetPassword(ctx, u, hash); err != nil {
+		return err
+	}
+
+	fmt.Printf("reset the password of %s\n", u.Username)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}